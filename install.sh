@@ -1,9 +1,9 @@
 #!/bin/sh
 echo "compiling.."
-go build
+go build -o jet.bin
 
 echo "copying jet to /usr/bin"
-sudo cp jet /usr/bin/
+sudo cp jet.bin /usr/bin/jet
 
 echo "installing jet manual.."
 gzip -c jet.1 > jet.1.gz
@@ -0,0 +1,211 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cell is a field of a CSV record between start and end, quotes included.
+type cell struct {
+	start  int
+	end    int
+	quoted bool
+}
+
+// isTable reports whether the file at path is a CSV or TSV file.
+func isTable(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".tsv", ".tab":
+		return true
+	}
+	return false
+}
+
+// parseDelimiter returns the byte represented by s, which can be a Go
+// escape sequence like \t.
+func parseDelimiter(s string) (byte, error) {
+	if u, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		s = u
+	}
+	if len(s) != 1 {
+		return 0, fmt.Errorf("invalid delimiter %q, expected a single character", s)
+	}
+	return s[0], nil
+}
+
+// csvDialect returns the delimiter and the quote of the file at path, the
+// quote being 0 if quoting is disabled.
+// The delimiter defaults to a tab for the TSV files and a comma otherwise.
+func (w *walker) csvDialect(path string) (delim, quote byte) {
+	delim = ','
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".tsv" || ext == ".tab" {
+		delim = '\t'
+	}
+	if w.CSVDelimiter != "" {
+		delim, _ = parseDelimiter(w.CSVDelimiter)
+	}
+	if w.CSVQuote != "" {
+		quote, _ = parseDelimiter(w.CSVQuote)
+	}
+	return
+}
+
+// splitRecords splits b in records and fields, keeping track of their
+// positions. The line endings, including the carriage returns before the
+// newlines, are not part of the fields.
+func splitRecords(b []byte, delim, quote byte) (records [][]cell) {
+	for i := 0; i < len(b); {
+		var rec []cell
+		for {
+			c := cell{start: i}
+			if quote != 0 && i < len(b) && b[i] == quote {
+				c.quoted = true
+				for i++; i < len(b); i++ {
+					if b[i] != quote {
+						continue
+					}
+					// Two quotes are an escaped quote.
+					if i+1 < len(b) && b[i+1] == quote {
+						i++
+						continue
+					}
+					i++
+					break
+				}
+			}
+			for i < len(b) && b[i] != delim && b[i] != '\n' {
+				i++
+			}
+
+			c.end = i
+			if c.end > c.start && b[c.end-1] == '\r' && (i == len(b) || b[i] == '\n') {
+				c.end--
+			}
+			rec = append(rec, c)
+
+			if i < len(b) && b[i] == delim {
+				i++
+				continue
+			}
+			i++
+			break
+		}
+		records = append(records, rec)
+	}
+	return
+}
+
+// value returns the unquoted content of c in b.
+func (c cell) value(b []byte, quote byte) []byte {
+	v := b[c.start:c.end]
+	if !c.quoted || len(v) < 2 || v[len(v)-1] != quote {
+		return v
+	}
+	q := []byte{quote}
+	return bytes.ReplaceAll(v[1:len(v)-1], append(q, quote), q)
+}
+
+// quoteCell returns v quoted if the cell was quoted or if it needs to be.
+func quoteCell(v []byte, quoted bool, delim, quote byte) []byte {
+	if quote == 0 {
+		return v
+	}
+	if !quoted && bytes.IndexByte(v, delim) < 0 && bytes.IndexByte(v, quote) < 0 && bytes.IndexAny(v, "\r\n") < 0 {
+		return v
+	}
+
+	q := []byte{quote}
+	v = bytes.ReplaceAll(v, q, append(q, quote))
+	return append(append(append([]byte{}, quote), v...), quote)
+}
+
+// columnIndexes returns the indexes of the columns in CSVColumns and
+// whether the first record is a header, that is if any column is specified
+// by name. The indexes specified by the user start from 1.
+func (w *walker) columnIndexes(header []cell, b []byte, quote byte) (map[int]bool, bool, error) {
+	var (
+		cols     = make(map[int]bool)
+		byName   bool
+		names    = make(map[string]int)
+		notFound []string
+	)
+
+	for i, c := range header {
+		if _, ok := names[string(c.value(b, quote))]; !ok {
+			names[string(c.value(b, quote))] = i
+		}
+	}
+
+	for _, col := range w.CSVColumns {
+		if n, err := strconv.Atoi(col); err == nil && n > 0 {
+			cols[n-1] = true
+			continue
+		}
+		byName = true
+		if i, ok := names[col]; ok {
+			cols[i] = true
+		} else {
+			notFound = append(notFound, strconv.Quote(col))
+		}
+	}
+
+	if len(notFound) > 0 {
+		return nil, false, fmt.Errorf("no column named %s", strings.Join(notFound, ", "))
+	}
+	return cols, byName, nil
+}
+
+// replaceColumns returns the content b of the CSV file at path with the
+// pairs applied to each cell of the columns in CSVColumns.
+// The rest of the file is preserved byte for byte.
+func (w *walker) replaceColumns(path string, b []byte) []byte {
+	delim, quote := w.csvDialect(path)
+	records := splitRecords(b, delim, quote)
+	if len(records) == 0 {
+		return b
+	}
+
+	cols, header, err := w.columnIndexes(records[0], b, quote)
+	if err != nil {
+		w.fail(fmt.Errorf("%s: %w", path, err))
+		return b
+	}
+	if header {
+		records = records[1:]
+	}
+
+	var edits []edit
+	for _, rec := range records {
+		for i, c := range rec {
+			if !cols[i] {
+				continue
+			}
+			v := c.value(b, quote)
+			if r := w.pairs.replaceAll(v); !bytes.Equal(r, v) {
+				edits = append(edits, edit{c.start, c.end, string(quoteCell(r, c.quoted, delim, quote))})
+			}
+		}
+	}
+	return applyEdits(b, edits)
+}
@@ -0,0 +1,112 @@
+package jet
+
+import (
+	"regexp"
+	"testing"
+)
+
+// TestSplitRecords tests that the fields are found with their quotes and
+// without the line endings.
+func TestSplitRecords(t *testing.T) {
+	input := "a,\"b,\"\"c\"\"\",d\r\n\"multi\nline\",,e\nlast"
+	expected := [][]string{
+		{"a", `"b,""c"""`, "d"},
+		{"\"multi\nline\"", "", "e"},
+		{"last"},
+	}
+
+	records := splitRecords([]byte(input), ',', '"')
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for i, rec := range records {
+		var fields []string
+		for _, c := range rec {
+			fields = append(fields, input[c.start:c.end])
+		}
+		if !equalStrings(fields, expected[i]) {
+			t.Errorf("record %d = %q; want %q", i, fields, expected[i])
+		}
+	}
+}
+
+// TestWalkerReplaceColumns tests that only the cells of the selected columns
+// are replaced and the rest of the file is preserved.
+func TestWalkerReplaceColumns(t *testing.T) {
+	tests := []struct {
+		path      string
+		columns   []string
+		delimiter string
+		quote     string
+		repl      string
+		input     string
+		expected  string
+	}{
+		{
+			"a.csv", []string{"city"}, "", `"`, "bar",
+			"name,city\r\nfoo,foo\r\n\"foo\",\"foo \"\"x\"\"\"\r\n",
+			"name,city\r\nfoo,bar\r\n\"foo\",\"bar \"\"x\"\"\"\r\n",
+		},
+		{
+			"a.csv", []string{"2"}, "", `"`, "bar",
+			"foo,foo\nfoo,foo,foo\nfoo\n",
+			"foo,bar\nfoo,bar,foo\nfoo\n",
+		},
+		{
+			"a.tsv", []string{"1", "3"}, "", `"`, "bar",
+			"foo\tfoo\tfoo\n",
+			"bar\tfoo\tbar\n",
+		},
+		// The replacement is quoted if it contains the delimiter.
+		{
+			"a.csv", []string{"1"}, "", `"`, "b,r",
+			"foo,foo\n",
+			"\"b,r\",foo\n",
+		},
+		{
+			"a.txt", []string{"2"}, ";", "", "bar",
+			"\"foo;foo\n",
+			"\"foo;bar\n",
+		},
+	}
+
+	for _, test := range tests {
+		w := &walker{
+			Options: Options{
+				CSVColumns:   test.columns,
+				CSVDelimiter: test.delimiter,
+				CSVQuote:     test.quote,
+			},
+			pairs: pairset{
+				{pattern: regexp.MustCompile("foo"), replacement: []byte(test.repl)},
+			},
+		}
+
+		if result := string(w.replace(test.path, []byte(test.input))); result != test.expected {
+			t.Errorf("replace(%q) with columns %v = %q; want %q", test.input, test.columns, result, test.expected)
+		}
+	}
+}
+
+// TestWalkerReplaceColumns_UnknownName tests that a file without the given
+// column is left unchanged.
+func TestWalkerReplaceColumns_UnknownName(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			CSVColumns: []string{"missing"},
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	input := "name\nfoo\n"
+	output := captureStdout(func() {
+		if result := string(w.replace("a.csv", []byte(input))); result != input {
+			t.Errorf("expected the content to be unchanged, got %q", result)
+		}
+	})
+	if output != "a.csv: no column named \"missing\"\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+}
@@ -0,0 +1,313 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// codec converts the text in a character encoding from and to UTF-8.
+type codec struct {
+	name   string
+	bom    []byte
+	decode func([]byte) ([]byte, error)
+	encode func([]byte) ([]byte, error)
+}
+
+var (
+	utf8Codec    = &codec{"UTF-8", []byte{0xEF, 0xBB, 0xBF}, identity, identity}
+	utf16LECodec = &codec{"UTF-16LE", []byte{0xFF, 0xFE}, decodeUTF16(binary.LittleEndian), encodeUTF16(binary.LittleEndian)}
+	utf16BECodec = &codec{"UTF-16BE", []byte{0xFE, 0xFF}, decodeUTF16(binary.BigEndian), encodeUTF16(binary.BigEndian)}
+	utf32LECodec = &codec{"UTF-32LE", []byte{0xFF, 0xFE, 0, 0}, decodeUTF32(binary.LittleEndian), encodeUTF32(binary.LittleEndian)}
+	utf32BECodec = &codec{"UTF-32BE", []byte{0, 0, 0xFE, 0xFF}, decodeUTF32(binary.BigEndian), encodeUTF32(binary.BigEndian)}
+	latin1Codec  = &codec{"ISO-8859-1", nil, decodeLatin1, encodeLatin1}
+	cp1252Codec  = &codec{"windows-1252", nil, decodeWindows1252, encodeWindows1252}
+
+	// codecs are the supported encodings by their normalized name.
+	codecs = map[string]*codec{
+		"utf8":        utf8Codec,
+		"utf16le":     utf16LECodec,
+		"utf16be":     utf16BECodec,
+		"utf32le":     utf32LECodec,
+		"utf32be":     utf32BECodec,
+		"latin1":      latin1Codec,
+		"iso88591":    latin1Codec,
+		"windows1252": cp1252Codec,
+		"cp1252":      cp1252Codec,
+	}
+
+	// The UTF-32 BOMs are checked first since the UTF-16LE one is a prefix of
+	// the UTF-32LE one.
+	bomCodecs = []*codec{utf32LECodec, utf32BECodec, utf8Codec, utf16LECodec, utf16BECodec}
+)
+
+// encoding is the encoding of a file, including its BOM if any.
+type encoding struct {
+	*codec
+	bom []byte
+}
+
+// encode returns text encoded back in e, preceded by the BOM.
+func (e encoding) encode(text []byte) ([]byte, error) {
+	b, err := e.codec.encode(text)
+	if err != nil {
+		return nil, err
+	}
+	return append(e.bom[:len(e.bom):len(e.bom)], b...), nil
+}
+
+// lookupCodec returns the codec of the encoding with the given name.
+func lookupCodec(name string) (*codec, error) {
+	n := strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	if c, ok := codecs[n]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q, expected one of utf-8, utf-16le, utf-16be, utf-32le, utf-32be, latin1 or windows-1252", name)
+}
+
+// detectWide returns the codec of b if it starts with a BOM or looks like
+// UTF-16 text without BOM, and the length of the BOM.
+// It returns nil if b is encoded with a single byte per ASCII character.
+func detectWide(b []byte) (*codec, int) {
+	for _, c := range bomCodecs {
+		if bytes.HasPrefix(b, c.bom) {
+			return c, len(c.bom)
+		}
+	}
+
+	// Mostly ASCII UTF-16 text has a NUL in every other byte.
+	if len(b) < 4 || len(b)%2 != 0 {
+		return nil, 0
+	}
+	head := b
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	var even, odd int
+	for i, c := range head {
+		if c == 0 && i%2 == 0 {
+			even++
+		} else if c == 0 {
+			odd++
+		}
+	}
+	switch pairs := len(head) / 2; {
+	case odd >= pairs/2 && even <= pairs/16:
+		return utf16LECodec, 0
+	case even >= pairs/2 && odd <= pairs/16:
+		return utf16BECodec, 0
+	}
+	return nil, 0
+}
+
+// detectEncoding returns the encoding of b: the one of its BOM, UTF-16 for
+// text with NULs every other byte, UTF-8 if mostly valid, windows-1252
+// otherwise. Binary files with NULs and UTF-8 files with a few invalid bytes
+// are considered UTF-8 and are left as they are.
+func detectEncoding(b []byte) encoding {
+	if c, n := detectWide(b); c != nil {
+		return encoding{c, b[:n]}
+	}
+	if mostlyUTF8(b) || bytes.IndexByte(b, 0) >= 0 {
+		return encoding{utf8Codec, nil}
+	}
+	return encoding{cp1252Codec, nil}
+}
+
+// mostlyUTF8 reports whether the invalid bytes of b don't outnumber its
+// valid multibyte UTF-8 characters.
+func mostlyUTF8(b []byte) bool {
+	var valid, invalid int
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		if r == utf8.RuneError && n == 1 {
+			invalid++
+		} else if n > 1 {
+			valid++
+		}
+		b = b[n:]
+	}
+	return invalid <= valid
+}
+
+// decode returns the encoding of b and its content converted to UTF-8.
+// The encoding is the one set with --encoding or the detected one.
+// An error is returned if the content can't be converted back unchanged.
+func (w *walker) decode(b []byte) (encoding, []byte, error) {
+	enc := detectEncoding(b)
+	if w.Encoding != "" {
+		c, err := lookupCodec(w.Encoding)
+		if err != nil {
+			return encoding{}, nil, err
+		}
+		enc.codec, enc.bom = c, nil
+		if c.bom != nil && bytes.HasPrefix(b, c.bom) {
+			enc.bom = b[:len(c.bom)]
+		}
+	}
+
+	raw := b[len(enc.bom):]
+	text, err := enc.codec.decode(raw)
+	if err != nil {
+		return encoding{}, nil, fmt.Errorf("cannot decode as %s: %w", enc.name, err)
+	}
+	if enc.codec != utf8Codec {
+		if back, err := enc.codec.encode(text); err != nil || !bytes.Equal(back, raw) {
+			return encoding{}, nil, fmt.Errorf("cannot decode as %s without altering the content", enc.name)
+		}
+	}
+	return enc, text, nil
+}
+
+// streamsRaw reports whether the file at path can be streamed as it is,
+// without converting it to UTF-8.
+func (w *walker) streamsRaw(path string) bool {
+	if w.Encoding != "" {
+		c, err := lookupCodec(w.Encoding)
+		return err == nil && c == utf8Codec
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(f, head)
+	c, _ := detectWide(head[:n&^1])
+	return c == nil || c == utf8Codec
+}
+
+func identity(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+func decodeUTF16(order binary.ByteOrder) func([]byte) ([]byte, error) {
+	return func(b []byte) ([]byte, error) {
+		if len(b)%2 != 0 {
+			return nil, fmt.Errorf("odd number of bytes")
+		}
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = order.Uint16(b[2*i:])
+		}
+		return []byte(string(utf16.Decode(u))), nil
+	}
+}
+
+func encodeUTF16(order binary.ByteOrder) func([]byte) ([]byte, error) {
+	return func(text []byte) ([]byte, error) {
+		u := utf16.Encode([]rune(string(text)))
+		b := make([]byte, 2*len(u))
+		for i, c := range u {
+			order.PutUint16(b[2*i:], c)
+		}
+		return b, nil
+	}
+}
+
+func decodeUTF32(order binary.ByteOrder) func([]byte) ([]byte, error) {
+	return func(b []byte) ([]byte, error) {
+		if len(b)%4 != 0 {
+			return nil, fmt.Errorf("number of bytes not multiple of 4")
+		}
+		out := make([]byte, 0, len(b))
+		for i := 0; i < len(b); i += 4 {
+			out = utf8.AppendRune(out, rune(order.Uint32(b[i:])))
+		}
+		return out, nil
+	}
+}
+
+func encodeUTF32(order binary.ByteOrder) func([]byte) ([]byte, error) {
+	return func(text []byte) ([]byte, error) {
+		runes := []rune(string(text))
+		b := make([]byte, 4*len(runes))
+		for i, r := range runes {
+			order.PutUint32(b[4*i:], uint32(r))
+		}
+		return b, nil
+	}
+}
+
+func decodeLatin1(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		out = utf8.AppendRune(out, rune(c))
+	}
+	return out, nil
+}
+
+func encodeLatin1(text []byte) ([]byte, error) {
+	b := make([]byte, 0, len(text))
+	for _, r := range string(text) {
+		if r > 0xFF {
+			return nil, fmt.Errorf("%q cannot be encoded in ISO-8859-1", r)
+		}
+		b = append(b, byte(r))
+	}
+	return b, nil
+}
+
+// cp1252 are the characters of windows-1252 between 0x80 and 0x9F.
+// The unassigned bytes are mapped to the C1 control characters, so that any
+// content can be decoded and encoded back unchanged.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(b []byte) ([]byte, error) {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		r := rune(c)
+		if c >= 0x80 && c < 0xA0 {
+			r = cp1252[c-0x80]
+		}
+		out = utf8.AppendRune(out, r)
+	}
+	return out, nil
+}
+
+func encodeWindows1252(text []byte) ([]byte, error) {
+	b := make([]byte, 0, len(text))
+next:
+	for _, r := range string(text) {
+		if r < 0x80 || r >= 0xA0 && r <= 0xFF {
+			b = append(b, byte(r))
+			continue
+		}
+		for i, c := range cp1252 {
+			if c == r {
+				b = append(b, byte(0x80+i))
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("%q cannot be encoded in windows-1252", r)
+	}
+	return b, nil
+}
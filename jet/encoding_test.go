@@ -0,0 +1,175 @@
+package jet
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// utf16LE returns s encoded in UTF-16LE.
+func utf16LE(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return b
+}
+
+// TestDetectEncoding tests the detectEncoding function.
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		expected *codec
+		bom      int
+	}{
+		{[]byte("plain ascii"), utf8Codec, 0},
+		{[]byte("\xEF\xBB\xBFcaffè"), utf8Codec, 3},
+		{append([]byte{0xFF, 0xFE}, utf16LE("foo")...), utf16LECodec, 2},
+		{[]byte{0xFE, 0xFF, 0, 'a'}, utf16BECodec, 2},
+		{[]byte{0xFF, 0xFE, 0, 0, 'a', 0, 0, 0}, utf32LECodec, 4},
+		{utf16LE("no bom here"), utf16LECodec, 0},
+		{[]byte("caff\xe8 \x93quoted\x94"), cp1252Codec, 0},
+		{[]byte("binary\x00\xff\xfe"), utf8Codec, 0},
+		{[]byte("caff\u00e8 na\u00efve \xff"), utf8Codec, 0},
+		{[]byte("caff\xe8 na\xefve \u00e8"), cp1252Codec, 0},
+	}
+
+	for _, test := range tests {
+		enc := detectEncoding(test.input)
+		if enc.codec != test.expected || len(enc.bom) != test.bom {
+			t.Errorf("detectEncoding(%q) = %s with %d bytes BOM; want %s with %d", test.input, enc.name, len(enc.bom), test.expected.name, test.bom)
+		}
+	}
+}
+
+// TestCodecsRoundTrip tests that decoding and encoding back gives the
+// original content.
+func TestCodecsRoundTrip(t *testing.T) {
+	var all []byte
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
+	}
+
+	for _, c := range []*codec{latin1Codec, cp1252Codec, utf16LECodec, utf16BECodec} {
+		input := all
+		if c == utf16LECodec || c == utf16BECodec {
+			input = utf16LE("àèìòù €")
+			if c == utf16BECodec {
+				for i := 0; i < len(input); i += 2 {
+					input[i], input[i+1] = input[i+1], input[i]
+				}
+			}
+		}
+
+		text, err := c.decode(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := c.encode(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(back, input) {
+			t.Errorf("%s round trip = %q; want %q", c.name, back, input)
+		}
+	}
+}
+
+// TestWalkerEdit_Encodings tests that the files are decoded before matching
+// and written back in their original encoding, in line mode as well.
+func TestWalkerEdit_Encodings(t *testing.T) {
+	tests := []struct {
+		encoding string
+		input    []byte
+		expected []byte
+	}{
+		{"", append([]byte{0xFF, 0xFE}, utf16LE("caffè foo")...), append([]byte{0xFF, 0xFE}, utf16LE("caffè bär")...)},
+		{"", []byte("caff\xe8 foo \x80"), []byte("caff\xe8 b\xe4r \x80")},
+		{"", []byte("caff\u00e8 foo \x80"), []byte("caff\u00e8 b\u00e4r \x80")},
+		{"latin1", []byte("foo\xe8"), []byte("b\xe4r\xe8")},
+		{"utf-8", []byte("\xEF\xBB\xBFfoo"), []byte("\xEF\xBB\xBFbär")},
+	}
+
+	for _, test := range tests {
+		for _, lineMode := range []bool{false, true} {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(path, test.input, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			w := &walker{
+				Options: Options{
+					Encoding: test.encoding,
+					LineMode: lineMode,
+				},
+				pairs: pairset{
+					{pattern: regexp.MustCompile("foo"), replacement: []byte("bär")},
+				},
+			}
+			w.edit(path)
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, test.expected) {
+				t.Errorf("edit of %q with encoding %q, line mode %t = %q; want %q", test.input, test.encoding, lineMode, content, test.expected)
+			}
+		}
+	}
+}
+
+// TestWalkerEdit_Unencodable tests that the files are left unchanged if the
+// replacement can't be encoded in their encoding.
+func TestWalkerEdit_Unencodable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	input := []byte("caff\xe8 foo")
+	if err := os.WriteFile(path, input, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &walker{
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("日本")},
+		},
+	}
+	output := captureStdout(func() {
+		w.edit(path)
+	})
+	if !strings.Contains(output, "cannot be encoded in windows-1252") {
+		t.Errorf("expected an encoding error, got %q", output)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, input) {
+		t.Errorf("expected the file to be unchanged, got %q", content)
+	}
+}
+
+// TestWalkerStreamsRaw tests that the UTF-16 files are not streamed.
+func TestWalkerStreamsRaw(t *testing.T) {
+	dir := newTree(t, map[string]string{
+		"utf8.txt":  strings.Repeat("foo ", 512),
+		"utf16.txt": string(utf16LE(strings.Repeat("foo ", 512))),
+	})
+
+	w := &walker{}
+	if !w.streamsRaw(filepath.Join(dir, "utf8.txt")) {
+		t.Errorf("expected the UTF-8 file to be streamed")
+	}
+	if w.streamsRaw(filepath.Join(dir, "utf16.txt")) {
+		t.Errorf("expected the UTF-16 file not to be streamed")
+	}
+
+	w.Encoding = "latin1"
+	if w.streamsRaw(filepath.Join(dir, "utf8.txt")) {
+		t.Errorf("expected the files not to be streamed with --encoding latin1")
+	}
+}
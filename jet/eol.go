@@ -0,0 +1,212 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// The line ending modes set with --eol.
+const (
+	eolPreserve = "preserve"
+	eolLF       = "lf"
+	eolCRLF     = "crlf"
+)
+
+var (
+	lf   = []byte("\n")
+	crlf = []byte("\r\n")
+)
+
+// parseEOL checks that mode is one of the line ending modes.
+func parseEOL(mode string) error {
+	switch mode {
+	case "", eolPreserve, eolLF, eolCRLF:
+		return nil
+	}
+	return fmt.Errorf("invalid line ending %q, expected lf, crlf or preserve", mode)
+}
+
+// convertsEOL reports whether the line endings of the files are converted.
+func (w *walker) convertsEOL() bool {
+	return w.EOL == eolLF || w.EOL == eolCRLF
+}
+
+// isCRLF reports whether all the line endings in b are CRLF.
+// The files with mixed line endings are not considered CRLF, so that their
+// LF line endings are not changed when preserving them.
+func isCRLF(b []byte) bool {
+	n := bytes.Count(b, crlf)
+	return n > 0 && n == bytes.Count(b, lf)
+}
+
+// lineEndings reports whether the CRLF line endings of b are normalized to
+// LF before matching, and whether the LF line endings are turned into CRLF
+// afterwards.
+func (w *walker) lineEndings(b []byte) (normalize, restore bool) {
+	switch w.EOL {
+	case eolLF:
+		return true, false
+	case eolCRLF:
+		return true, true
+	}
+	c := isCRLF(b)
+	return c, c
+}
+
+// toLF returns b with the CRLF line endings replaced by LF.
+func toLF(b []byte) []byte {
+	return bytes.ReplaceAll(b, crlf, lf)
+}
+
+// toCRLF returns b with the LF line endings replaced by CRLF.
+// It is only used on content normalized with toLF.
+func toCRLF(b []byte) []byte {
+	return bytes.ReplaceAll(b, lf, crlf)
+}
+
+// changesEOL reports whether the line endings of b would be converted.
+func (w *walker) changesEOL(b []byte) bool {
+	switch w.EOL {
+	case eolLF:
+		return bytes.Contains(b, crlf)
+	case eolCRLF:
+		return bytes.Count(b, crlf) != bytes.Count(b, lf)
+	}
+	return false
+}
+
+// eolState is the state of a stream whose CRLF line endings are preserved.
+// The stream is CRLF as long as no LF line ending is read, afterwards it is
+// mixed and its remaining content is written through unchanged.
+type eolState struct {
+	mixed bool
+	// read is the number of CRLF line endings read as LF before the stream
+	// is found mixed and written the number of them restored as CRLF.
+	read    int
+	written int
+}
+
+// lfReader is an io.Reader that converts the CRLF line endings read from r
+// to LF. If state is not nil it stops at the first LF line ending.
+type lfReader struct {
+	r     *bufio.Reader
+	state *eolState
+	// cr is set if the last CR read has been dropped.
+	cr bool
+}
+
+func (l *lfReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if l.state != nil && l.state.mixed {
+		return n, err
+	}
+
+	j := 0
+	for i := 0; i < n; i++ {
+		switch p[i] {
+		case '\r':
+			if i+1 < n && p[i+1] == '\n' {
+				l.cr = true
+				continue
+			}
+			// The LF might be in the next chunk.
+			if i+1 == n {
+				if next, _ := l.r.Peek(1); len(next) == 1 && next[0] == '\n' {
+					l.cr = true
+					continue
+				}
+			}
+		case '\n':
+			if l.state != nil {
+				if !l.cr {
+					l.state.mixed = true
+					return j + copy(p[j:], p[i:n]), err
+				}
+				l.state.read++
+			}
+		}
+		l.cr = false
+		p[j] = p[i]
+		j++
+	}
+	return j, err
+}
+
+// crlfWriter is an io.Writer that converts the LF line endings written to
+// it to CRLF. If state is not nil, only the ones read as CRLF are converted
+// once the stream is found mixed.
+type crlfWriter struct {
+	w     io.Writer
+	state *eolState
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	b := toCRLF(p)
+	if c.state != nil {
+		n := bytes.Count(p, lf)
+		if c.state.mixed {
+			// Convert the line endings up to the first LF one read.
+			end := 0
+			for n = 0; c.state.written+n < c.state.read; n++ {
+				i := bytes.IndexByte(p[end:], '\n')
+				if i < 0 {
+					break
+				}
+				end += i + 1
+			}
+			b = append(toCRLF(p[:end]), p[end:]...)
+		}
+		c.state.written += n
+	}
+
+	if _, err := c.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// eolStreams returns dst and src wrapped to normalize and restore the line
+// endings like lineEndings. The line endings of the streamed content are
+// detected from the data available with the first read, so that reading
+// from a pipe doesn't block. When preserving them, the stream stops being
+// normalized at the first LF line ending, like the files with mixed line
+// endings in memory.
+func (w *walker) eolStreams(dst io.Writer, src io.Reader) (io.Writer, io.Reader) {
+	r := bufio.NewReaderSize(src, chunkSize)
+	r.Peek(1)
+	head, _ := r.Peek(r.Buffered())
+
+	normalize, restore := w.lineEndings(head)
+	if !normalize {
+		return dst, r
+	}
+
+	var state *eolState
+	if !w.convertsEOL() {
+		state = new(eolState)
+	}
+	if restore {
+		dst = crlfWriter{dst, state}
+	}
+	return dst, &lfReader{r: r, state: state}
+}
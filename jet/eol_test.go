@@ -0,0 +1,167 @@
+package jet
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// TestWalkerLineEndings tests the lineEndings method of the walker type.
+func TestWalkerLineEndings(t *testing.T) {
+	tests := []struct {
+		eol       string
+		input     string
+		normalize bool
+		restore   bool
+	}{
+		{eolPreserve, "foo\nbar\n", false, false},
+		{eolPreserve, "foo\r\nbar\r\n", true, true},
+		{eolPreserve, "foo\r\nbar\n", false, false},
+		{eolPreserve, "foo", false, false},
+		{eolLF, "foo\r\nbar\r\n", true, false},
+		{eolCRLF, "foo\nbar\n", true, true},
+	}
+
+	for _, test := range tests {
+		w := &walker{Options: Options{EOL: test.eol}}
+		normalize, restore := w.lineEndings([]byte(test.input))
+		if normalize != test.normalize || restore != test.restore {
+			t.Errorf("lineEndings(%q) with %s = %t, %t; want %t, %t", test.input, test.eol, normalize, restore, test.normalize, test.restore)
+		}
+	}
+}
+
+// TestLfReader tests that the CRLF line endings are converted even if they
+// are split between two reads.
+func TestLfReader(t *testing.T) {
+	input := "foo\r\nbar\r\r\nbaz\rqux\r\n"
+	r := &lfReader{r: bufio.NewReader(iotest.OneByteReader(strings.NewReader(input)))}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "foo\nbar\r\nbaz\rqux\n"; string(b) != expected {
+		t.Errorf("lfReader(%q) = %q; want %q", input, b, expected)
+	}
+}
+
+// TestWalkerEdit_EOL tests that the patterns match the content with the
+// line endings normalized and that the line endings are preserved or
+// converted when writing.
+func TestWalkerEdit_EOL(t *testing.T) {
+	tests := []struct {
+		eol       string
+		lineMode  bool
+		threshold int64
+		input     string
+		expected  string
+	}{
+		{eolPreserve, false, 0, "foo\r\nbar foo\r\n", "fo0\r\nbar fo0\r\n"},
+		{eolPreserve, false, 0, "foo\r\nfoo\n", "foo\r\nfo0\n"},
+		{eolPreserve, true, 0, "foo\r\nfoo\n", "fo0\r\nfo0\n"},
+		{eolPreserve, false, 1, "foo\r\nbar foo\r\n", "fo0\r\nbar fo0\r\n"},
+		{eolLF, false, 0, "foo\r\nbar\r\nfoo\n", "fo0\nbar\nfo0\n"},
+		{eolCRLF, false, 0, "foo\nbar\r\nfoo\n", "fo0\r\nbar\r\nfo0\r\n"},
+		{eolCRLF, true, 0, "foo\nbar\r\n", "fo0\r\nbar\r\n"},
+		{eolCRLF, false, 1, "foo\nbar\r\n", "fo0\r\nbar\r\n"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "file.txt")
+		if err := os.WriteFile(path, []byte(test.input), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		w := &walker{
+			Options: Options{
+				EOL:             test.eol,
+				LineMode:        test.lineMode,
+				StreamThreshold: test.threshold,
+			},
+			pairs: pairset{
+				{pattern: regexp.MustCompile(`(?m)o$`), replacement: []byte("0")},
+			},
+		}
+		w.edit(path)
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != test.expected {
+			t.Errorf("edit(%q) with %s, -L %t, threshold %d = %q; want %q", test.input, test.eol, test.lineMode, test.threshold, content, test.expected)
+		}
+	}
+}
+
+// TestWalkerEdit_EOLMixedStream tests that the line endings of a streamed
+// file found mixed after the first read are preserved.
+func TestWalkerEdit_EOLMixedStream(t *testing.T) {
+	crlfLines := strings.Repeat("foo\r\n", 2*chunkSize/5)
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte(crlfLines+"foo\nfoo\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &walker{
+		Options: Options{
+			EOL:             eolPreserve,
+			StreamThreshold: 1,
+			MaxMatch:        defaultMaxMatch,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile(`(?m)o$`), replacement: []byte("0")},
+		},
+	}
+	w.edit(path)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The CRLF line endings after the first LF one are not normalized.
+	expected := strings.ReplaceAll(crlfLines, "o\r", "0\r") + "fo0\nfoo\r\n"
+	if string(content) != expected {
+		t.Errorf("expected the line endings to be preserved, got a different content of %d bytes ending with %q", len(content), content[len(content)-20:])
+	}
+}
+
+// TestWalkerWalk_EOLOnly tests that the line endings are converted without
+// any pattern.
+func TestWalkerWalk_EOLOnly(t *testing.T) {
+	files := map[string]string{
+		"a.txt":     "foo\r\nbar\r\n",
+		"sub/b.txt": "baz\r\n",
+	}
+	dir := newTree(t, files)
+
+	w := newRenameWalker()
+	w.ReplaceNames = false
+	w.EOL = eolLF
+	w.Walk(dir)
+
+	for name, content := range files {
+		files[name] = strings.ReplaceAll(content, "\r\n", "\n")
+	}
+	checkTree(t, dir, files)
+}
+
+// TestWalkerWouldTouch_EOL tests that the files whose line endings are
+// converted are considered touched.
+func TestWalkerWouldTouch_EOL(t *testing.T) {
+	dir := newTree(t, map[string]string{"crlf.txt": "a\r\n", "lf.txt": "a\n"})
+
+	w := &walker{Options: Options{EOL: eolLF, Glob: "*", MaxDepth: -1}}
+	if !w.wouldTouch(dir, filepath.Join(dir, "crlf.txt")) {
+		t.Errorf("expected crlf.txt to be touched")
+	}
+	if w.wouldTouch(dir, filepath.Join(dir, "lf.txt")) {
+		t.Errorf("expected lf.txt not to be touched")
+	}
+}
@@ -0,0 +1,234 @@
+//go:build ignore
+
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// gennorm generates the Unicode normalization tables in normtables.go from
+// the UnicodeData.txt and CompositionExclusions.txt files of the Unicode
+// Character Database, read from a directory or a URL.
+//
+//	go run gennorm.go -version 14.0.0
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type decomposition struct {
+	compat bool
+	runes  []rune
+}
+
+var (
+	ccc     = make(map[rune]uint8)
+	decomps = make(map[rune]decomposition)
+	exclude = make(map[rune]bool)
+)
+
+func open(ucd, name string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(ucd, "http://") && !strings.HasPrefix(ucd, "https://") {
+		return os.Open(filepath.Join(ucd, name))
+	}
+
+	resp, err := http.Get(strings.TrimSuffix(ucd, "/") + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// lines calls fn with the fields of each line of the file, without comments.
+func lines(ucd, name string, fn func([]string)) {
+	f, err := open(ucd, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		fn(fields)
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseRune(s string) rune {
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rune(n)
+}
+
+// decompose appends the full decomposition of r to dst.
+func decompose(dst []rune, r rune, compat bool) []rune {
+	d, ok := decomps[r]
+	if !ok || d.compat && !compat {
+		return append(dst, r)
+	}
+	for _, c := range d.runes {
+		dst = decompose(dst, c, compat)
+	}
+	return dst
+}
+
+func sortedKeys(m map[rune]string) []rune {
+	keys := make([]rune, 0, len(m))
+	for r := range m {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func main() {
+	version := flag.String("version", "14.0.0", "The Unicode version.")
+	ucd := flag.String("ucd", "", "The directory or the URL of the Unicode Character Database (default the one of the version on unicode.org).")
+	out := flag.String("o", "normtables.go", "The output file.")
+	flag.Parse()
+
+	if *ucd == "" {
+		*ucd = "https://www.unicode.org/Public/" + *version + "/ucd"
+	}
+
+	lines(*ucd, "UnicodeData.txt", func(f []string) {
+		r := parseRune(f[0])
+		if n, _ := strconv.Atoi(f[3]); n != 0 {
+			ccc[r] = uint8(n)
+		}
+		if f[5] == "" {
+			return
+		}
+		var d decomposition
+		for _, s := range strings.Fields(f[5]) {
+			if strings.HasPrefix(s, "<") {
+				d.compat = true
+				continue
+			}
+			d.runes = append(d.runes, parseRune(s))
+		}
+		decomps[r] = d
+	})
+	lines(*ucd, "CompositionExclusions.txt", func(f []string) {
+		exclude[parseRune(f[0])] = true
+	})
+
+	var (
+		nfd     = make(map[rune]string)
+		nfkd    = make(map[rune]string)
+		compose = make(map[[2]rune]rune)
+	)
+	for r, d := range decomps {
+		canon := string(decompose(nil, r, false))
+		if canon != string(r) {
+			nfd[r] = canon
+		}
+		if compat := string(decompose(nil, r, true)); compat != canon {
+			nfkd[r] = compat
+		}
+
+		// The singletons and the decompositions starting with a non-starter
+		// are excluded from the composition.
+		if !d.compat && len(d.runes) == 2 && !exclude[r] && ccc[r] == 0 && ccc[d.runes[0]] == 0 {
+			compose[[2]rune{d.runes[0], d.runes[1]}] = r
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gennorm.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "// Unicode version: %s\n\n", *version)
+	fmt.Fprintf(&b, "package jet\n\n")
+
+	fmt.Fprintf(&b, "// nfdTable maps the runes to their full canonical decomposition.\n")
+	fmt.Fprintf(&b, "var nfdTable = map[rune]string{\n")
+	for _, r := range sortedKeys(nfd) {
+		fmt.Fprintf(&b, "\t%#04x: %s,\n", r, strconv.QuoteToASCII(nfd[r]))
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "// nfkdTable maps the runes to their full compatibility decomposition\n")
+	fmt.Fprintf(&b, "// when it differs from the canonical one.\n")
+	fmt.Fprintf(&b, "var nfkdTable = map[rune]string{\n")
+	for _, r := range sortedKeys(nfkd) {
+		fmt.Fprintf(&b, "\t%#04x: %s,\n", r, strconv.QuoteToASCII(nfkd[r]))
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "// cccTable maps the runes to their non-zero canonical combining class.\n")
+	fmt.Fprintf(&b, "var cccTable = map[rune]uint8{\n")
+	var runes []rune
+	for r := range ccc {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	for _, r := range runes {
+		fmt.Fprintf(&b, "\t%#04x: %d,\n", r, ccc[r])
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "// composeTable maps the pairs of runes to their primary composite.\n")
+	fmt.Fprintf(&b, "var composeTable = map[[2]rune]rune{\n")
+	var pairs [][2]rune
+	for p := range compose {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	for _, p := range pairs {
+		fmt.Fprintf(&b, "\t{%#04x, %#04x}: %#04x,\n", p[0], p[1], compose[p])
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,408 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// git runs the git command with the given arguments in dir and returns its output.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git %s: %s", args[0], bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// splitNul splits the NUL separated output of a git command.
+func splitNul(b []byte) (s []string) {
+	for _, f := range bytes.Split(b, []byte{0}) {
+		if len(f) > 0 {
+			s = append(s, string(f))
+		}
+	}
+	return
+}
+
+// gitPathspec returns the directory in which to run git and the pathspec
+// matching path.
+func gitPathspec(path string) (dir, spec string, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+	if info.IsDir() {
+		return path, ".", nil
+	}
+	return filepath.Dir(path), filepath.Base(path), nil
+}
+
+// gitFiles returns the files tracked by git under path.
+// If changed is true only the files with changes in the working tree or in
+// the index are returned.
+func gitFiles(path string, changed bool) ([]string, error) {
+	dir, spec, err := gitPathspec(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if changed {
+		modified, err := git(dir, "ls-files", "-z", "--modified", "--", spec)
+		if err != nil {
+			return nil, err
+		}
+		staged, err := git(dir, "diff", "-z", "--cached", "--name-only", "--relative", "--diff-filter=d", "--", spec)
+		if err != nil {
+			return nil, err
+		}
+		names = append(splitNul(modified), splitNul(staged)...)
+	} else {
+		out, err := git(dir, "ls-files", "-z", "--", spec)
+		if err != nil {
+			return nil, err
+		}
+		names = splitNul(out)
+	}
+
+	var (
+		files []string
+		seen  = make(map[string]bool)
+	)
+	for _, n := range names {
+		f := filepath.Join(dir, filepath.FromSlash(n))
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+
+		// Skip the files deleted from the working tree.
+		if _, err := os.Lstat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// gitMove renames oldpath to newpath with git mv, so that the rename is
+// recorded in the index.
+func gitMove(oldpath, newpath string) error {
+	dir := filepath.Dir(oldpath)
+
+	rel, err := filepath.Rel(dir, newpath)
+	if err != nil {
+		return err
+	}
+	_, err = git(dir, "mv", "--", filepath.Base(oldpath), rel)
+	return err
+}
+
+// walkGit processes the files tracked by git under root, applying the same
+// depth and hidden files rules of a directory walk.
+func (w *walker) walkGit(root string) {
+	files, err := gitFiles(root, w.GitChanged)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	for _, f := range files {
+		if w.MaxDepth >= 0 && depth(filepath.Dir(f)) > w.MaxDepth {
+			continue
+		}
+		if !w.IncludeHidden && inHiddenDir(root, f) {
+			continue
+		}
+		w.processPath(f)
+	}
+
+	// git only lists the files, the directories containing them are
+	// renamed as in a directory walk.
+	if w.renaming() {
+		for _, d := range gitDirs(root, files) {
+			if !w.IncludeHidden && inHiddenDir(root, d) {
+				continue
+			}
+			w.processPath(d)
+		}
+	}
+}
+
+// gitDirs returns the directories between root, included if it is a
+// directory, and the given files, in lexical order.
+func gitDirs(root string, files []string) []string {
+	var (
+		dirs []string
+		seen = make(map[string]bool)
+		stop = filepath.Dir(walkRoot(root))
+	)
+	for _, f := range files {
+		for d := filepath.Dir(f); d != stop && !seen[d]; d = filepath.Dir(d) {
+			seen[d] = true
+			dirs = append(dirs, d)
+			if d == filepath.Dir(d) {
+				break
+			}
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// inHiddenDir reports whether any of the directories between root and path is hidden.
+func inHiddenDir(root, path string) bool {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	for _, name := range strings.Split(rel, string(os.PathSeparator)) {
+		if isHidden(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitDirtyFiles returns the files under path with uncommitted changes,
+// including the untracked ones that are not ignored if untracked is true.
+// It returns no files if path is not inside a git working tree or doesn't
+// exist, the walk reports it then.
+func gitDirtyFiles(path string, untracked bool) ([]string, error) {
+	dir, spec, err := gitPathspec(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// Nothing to check if git is not installed or path is not in a repository.
+	if _, err := git(dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, nil
+	}
+
+	files, err := gitFiles(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !untracked {
+		return files, nil
+	}
+	others, err := git(dir, "ls-files", "-z", "--others", "--exclude-standard", "--", spec)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range splitNul(others) {
+		files = append(files, filepath.Join(dir, filepath.FromSlash(n)))
+	}
+	return files, nil
+}
+
+// checkDirty returns an error listing the files under paths, or listed in
+// FilesFrom, with uncommitted changes that would be modified by the walker.
+func (w *walker) checkDirty(paths ...string) error {
+	// Nothing is modified when writing to OutDir, or when printing to
+	// stdout without renaming.
+	if w.OutDir != "" || (w.ToStdout && !w.renaming()) {
+		return nil
+	}
+	if w.UpdateRefs && w.renaming() {
+		w.refNames = w.renamedNames(paths)
+	}
+
+	var dirty []string
+	check := func(root string) error {
+		// Only the tracked files are processed with Git.
+		files, err := gitDirtyFiles(root, !w.Git)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if w.wouldTouch(root, f) {
+				dirty = append(dirty, f)
+			}
+		}
+		return nil
+	}
+
+	for _, p := range paths {
+		if p == "-" {
+			continue
+		}
+		if err := check(p); err != nil {
+			return err
+		}
+	}
+	// The listed directories are not descended into.
+	for _, p := range w.listed {
+		if info, err := os.Lstat(p); err == nil && !info.IsDir() {
+			if err := check(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(dirty) > 0 {
+		return &DirtyError{Paths: dirty}
+	}
+	return nil
+}
+
+// A DirtyError reports the files to modify that have uncommitted changes,
+// when AllowDirty is not set.
+type DirtyError struct {
+	Paths []string
+}
+
+func (e *DirtyError) Error() string {
+	return "refusing to edit files with uncommitted changes, commit or stash them or use AllowDirty:\n  " +
+		strings.Join(e.Paths, "\n  ")
+}
+
+// renamedNames returns the names, without extension, of the files and the
+// directories under paths or listed in FilesFrom that would be renamed.
+// The references to the renamed files contain at least one of them.
+func (w *walker) renamedNames(paths []string) []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+	add := func(rel string) {
+		for _, name := range strings.Split(rel, string(os.PathSeparator)) {
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			if name != "" && name != "." && name != ".." && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	match := func(root, path string) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return
+		}
+		switch {
+		case w.ReplacePaths && w.pairs.match([]byte(filepath.ToSlash(rel))):
+			add(rel)
+		case !w.ReplacePaths && w.pairs.match([]byte(filepath.Base(path))):
+			add(filepath.Base(path))
+		}
+	}
+
+	for _, p := range paths {
+		if p == "-" {
+			continue
+		}
+		root := walkRoot(p)
+		filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if path != p && !w.IncludeHidden && isHidden(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			match(root, path)
+			return nil
+		})
+	}
+	for _, p := range w.listed {
+		match(filepath.Dir(p), p)
+	}
+	return names
+}
+
+// wouldTouch reports whether the walker would modify or rename the file at
+// path when walking root.
+func (w *walker) wouldTouch(root, path string) bool {
+	if w.MaxDepth >= 0 && depth(filepath.Dir(path)) > w.MaxDepth {
+		return false
+	}
+	if !w.IncludeHidden && (isHidden(filepath.Base(path)) || inHiddenDir(root, path)) {
+		return false
+	}
+
+	if w.renaming() {
+		if rel, err := filepath.Rel(walkRoot(root), path); err == nil {
+			if w.ReplacePaths && w.pairs.match([]byte(filepath.ToSlash(rel))) {
+				return true
+			}
+			// Renaming one of the parent directories moves the file as well.
+			for _, name := range strings.Split(rel, string(os.PathSeparator)) {
+				if !w.ReplacePaths && w.pairs.match([]byte(name)) {
+					return true
+				}
+			}
+		}
+		if w.NamesOnly && w.refNames == nil {
+			return false
+		}
+	}
+
+	if !w.matchGlob(path) {
+		return false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// Be conservative if the file cannot be checked.
+		return true
+	}
+	// The references to the renamed files are updated.
+	for _, name := range w.refNames {
+		if bytes.Contains(b, []byte(name)) {
+			return true
+		}
+	}
+	if w.NamesOnly {
+		return false
+	}
+	if w.goIdent.isSet() && filepath.Ext(path) == ".go" && bytes.Contains(b, []byte(w.goIdent.name)) {
+		return true
+	}
+	if _, text, err := w.decode(b); err == nil {
+		b = text
+	}
+	if w.changesEOL(b) {
+		return true
+	}
+	if w.NormalizeOutput && !bytes.Equal(w.normForm().normalize(b), b) {
+		return true
+	}
+	if normalize, _ := w.lineEndings(b); normalize {
+		b = toLF(b)
+	}
+	return w.pairs.match(b)
+}
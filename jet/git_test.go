@@ -0,0 +1,223 @@
+package jet
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newGitRepo creates a git repository in a temporary directory with the
+// given files committed and returns its path.
+func newGitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := newTree(t, files)
+	run := func(args ...string) {
+		t.Helper()
+		if _, err := git(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "-q")
+	run("config", "user.name", "test")
+	run("config", "user.email", "test@example.com")
+	run("add", ".")
+	run("commit", "-q", "-m", "initial commit")
+	return dir
+}
+
+// TestGitFiles tests that only the tracked files are listed, optionally
+// restricted to the ones with uncommitted changes.
+func TestGitFiles(t *testing.T) {
+	dir := newGitRepo(t, map[string]string{
+		"a.txt":     "foo",
+		"b.txt":     "foo",
+		"sub/c.txt": "foo",
+	})
+
+	os.WriteFile(filepath.Join(dir, "untracked.txt"), []byte("foo"), 0644)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("foo modified"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "c.txt"), []byte("foo staged"), 0644)
+	if _, err := git(dir, "add", "sub/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		changed  bool
+		expected []string
+	}{
+		{false, []string{"a.txt", "b.txt", "sub/c.txt"}},
+		{true, []string{"a.txt", "sub/c.txt"}},
+	}
+
+	for _, test := range tests {
+		files, err := gitFiles(dir, test.changed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var rel []string
+		for _, f := range files {
+			r, _ := filepath.Rel(dir, f)
+			rel = append(rel, filepath.ToSlash(r))
+		}
+		sort.Strings(rel)
+
+		if !reflect.DeepEqual(rel, test.expected) {
+			t.Errorf("gitFiles(changed=%v) = %v; want %v", test.changed, rel, test.expected)
+		}
+	}
+}
+
+// TestWalkerWalkGit tests that in git mode only tracked files and their
+// directories are edited and renamed, and that renames are recorded in the
+// index.
+func TestWalkerWalkGit(t *testing.T) {
+	dir := newGitRepo(t, map[string]string{
+		"foo.txt":        "foo",
+		"foodir/foo.txt": "foo",
+		".hidden/a.go":   "foo",
+	})
+	os.WriteFile(filepath.Join(dir, "untracked.txt"), []byte("foo"), 0644)
+	os.MkdirAll(filepath.Join(dir, "foountracked"), 0755)
+
+	w := &walker{
+		Options: Options{
+			Glob:         "*",
+			MaxDepth:     -1,
+			Git:          true,
+			ReplaceNames: true,
+		},
+		WaitGroup: new(sync.WaitGroup),
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		w.Walk(dir)
+	})
+	if output != "" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	expected := map[string]string{
+		"bar.txt":        "bar",
+		"bardir/bar.txt": "bar",
+		"untracked.txt":  "foo",
+		".hidden/a.go":   "foo",
+	}
+	for name, want := range expected {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("expected %s to contain %q, got %q", name, want, content)
+		}
+	}
+
+	status, err := git(dir, "status", "--porcelain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []string{"foo.txt -> bar.txt", "foodir/foo.txt -> bardir/bar.txt"} {
+		if !strings.Contains(string(status), r) {
+			t.Errorf("expected the rename %s to be staged, got status:\n%s", r, status)
+		}
+	}
+	// The directories without tracked files are not renamed.
+	if _, err := os.Stat(filepath.Join(dir, "foountracked")); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestWalkerCheckDirty tests that only the dirty files that would be
+// modified make the check fail.
+func TestWalkerCheckDirty(t *testing.T) {
+	dir := newGitRepo(t, map[string]string{
+		"a.txt": "foo",
+		"b.txt": "baz",
+	})
+
+	w := &walker{
+		Options: Options{
+			Glob:     "*",
+			MaxDepth: -1,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	if err := w.checkDirty(dir); err != nil {
+		t.Errorf("expected no error for a clean repository, got %v", err)
+	}
+
+	// A dirty file without matches is not touched.
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("baz modified"), 0644)
+	if err := w.checkDirty(dir); err != nil {
+		t.Errorf("expected no error for a dirty file without matches, got %v", err)
+	}
+
+	// Untracked files cannot be restored by git either.
+	os.WriteFile(filepath.Join(dir, "new.txt"), []byte("foo"), 0644)
+	err := w.checkDirty(dir)
+	if err == nil || !strings.Contains(err.Error(), "new.txt") {
+		t.Errorf("expected error about new.txt, got %v", err)
+	}
+	// The untracked files are never processed with Git.
+	w.Git = true
+	if err := w.checkDirty(dir); err != nil {
+		t.Errorf("expected no error for an untracked file with Git, got %v", err)
+	}
+	w.Git = false
+	os.Remove(filepath.Join(dir, "new.txt"))
+
+	// The missing paths are left for the walk to report.
+	if err := w.checkDirty(dir, filepath.Join(dir, "missing.txt")); err != nil {
+		t.Errorf("expected no error for a missing path, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("foo modified"), 0644)
+	err = w.checkDirty(dir)
+	if err == nil || !strings.Contains(err.Error(), "a.txt") {
+		t.Errorf("expected error about a.txt, got %v", err)
+	}
+
+	// Nothing is modified when printing to stdout.
+	w.ToStdout = true
+	if err := w.checkDirty(dir); err != nil {
+		t.Errorf("expected no error when printing to stdout, got %v", err)
+	}
+}
+
+// TestWalkerCheckDirty_NotARepository tests that paths outside of a git
+// repository are always considered clean.
+func TestWalkerCheckDirty_NotARepository(t *testing.T) {
+	dir := newTree(t, map[string]string{"a.txt": "foo"})
+
+	w := &walker{
+		Options: Options{
+			Glob:     "*",
+			MaxDepth: -1,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+	if err := w.checkDirty(dir); err != nil {
+		t.Errorf("expected no error outside of a repository, got %v", err)
+	}
+}
@@ -0,0 +1,473 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goIdent is the Go identifier to rename, either a package level name or a
+// method or field in the form Type.Member, optionally in the package with
+// the import path pkg only.
+type goIdent struct {
+	pkg  string
+	typ  string
+	name string
+	new  string
+}
+
+// parseGoIdent returns the rename of the identifier old, in the form Name
+// or Type.Member, optionally prefixed by an import path as in
+// example.com/m/pkg.Name or "pkg".Name, to name.
+func parseGoIdent(old, name string) (goIdent, error) {
+	invalid := fmt.Errorf("invalid identifier %q, expected [importpath.]Name or [importpath.]Type.Member", old)
+
+	pkg, ident, ok := cutImportPath(old)
+	if !ok {
+		return goIdent{}, invalid
+	}
+	typ, member, ok := strings.Cut(ident, ".")
+	if !ok {
+		typ, member = "", typ
+	}
+	if typ != "" && !token.IsIdentifier(typ) || !token.IsIdentifier(member) {
+		return goIdent{}, invalid
+	}
+	if !token.IsIdentifier(name) {
+		return goIdent{}, fmt.Errorf("invalid identifier %q", name)
+	}
+	return goIdent{pkg: pkg, typ: typ, name: member, new: name}, nil
+}
+
+// cutImportPath splits s into the import path it starts with, quoted or
+// containing a slash, and the identifier following it.
+func cutImportPath(s string) (pkg, ident string, ok bool) {
+	if strings.HasPrefix(s, `"`) {
+		end := strings.Index(s[1:], `"`) + 1
+		if end == 0 || !strings.HasPrefix(s[end+1:], ".") {
+			return "", "", false
+		}
+		return s[1:end], s[end+2:], end > 1
+	}
+
+	slash := strings.LastIndex(s, "/")
+	if slash < 0 {
+		return "", s, true
+	}
+	dot := strings.Index(s[slash:], ".")
+	if dot < 0 {
+		return "", "", false
+	}
+	return s[:slash+dot], s[slash+dot+1:], true
+}
+
+// old returns the identifier to rename as it was specified.
+func (g goIdent) old() string {
+	name := g.name
+	if g.typ != "" {
+		name = g.typ + "." + name
+	}
+	if g.pkg != "" {
+		return strconv.Quote(g.pkg) + "." + name
+	}
+	return name
+}
+
+// isSet reports whether an identifier to rename has been specified.
+func (g goIdent) isSet() bool {
+	return g.name != ""
+}
+
+// posKey identifies a position across the different parses of the same file.
+type posKey struct {
+	file   string
+	offset int
+}
+
+// goPackage is a package parsed from the files of a single directory.
+type goPackage struct {
+	dir string
+	// path is the import path of the package, empty if it is not in a
+	// module.
+	path  string
+	files []*ast.File
+}
+
+// renameGoIdent renames the declarations and the uses of the identifier
+// specified with --go-ident in the walked Go files.
+// The packages containing them are type checked as a whole, tolerating the
+// type errors, and the imported packages are type checked from source.
+func (w *walker) renameGoIdent() {
+	if len(w.goFiles) == 0 {
+		return
+	}
+
+	var (
+		fset = token.NewFileSet()
+		srcs = make(map[string][]byte)
+		pkgs = w.parsePackages(fset, srcs)
+	)
+
+	conf := types.Config{
+		Importer: newSourceImporter(fset),
+		// Missing dependencies or broken code must not prevent renaming
+		// the identifiers that can be resolved.
+		Error: func(error) {},
+	}
+
+	type checked struct {
+		pkg  *types.Package
+		info *types.Info
+	}
+	var all []checked
+	for _, p := range pkgs {
+		info := &types.Info{
+			Defs: make(map[*ast.Ident]types.Object),
+			Uses: make(map[*ast.Ident]types.Object),
+		}
+		pkg, _ := conf.Check(p.files[0].Name.Name, fset, p.files, info)
+		all = append(all, checked{pkg, info})
+	}
+
+	targets := make(map[posKey]bool)
+	for i, p := range pkgs {
+		if err := w.goIdent.collect(targets, fset, p, all[i].pkg); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	edits := make(map[string][]edit)
+	add := func(id *ast.Ident, obj types.Object) {
+		if obj == nil || !targets[keyOf(fset, obj.Pos())] {
+			return
+		}
+		pos := fset.Position(id.Pos())
+		for _, e := range edits[pos.Filename] {
+			if e.start == pos.Offset {
+				return
+			}
+		}
+		edits[pos.Filename] = append(edits[pos.Filename], edit{pos.Offset, pos.Offset + len(id.Name), w.goIdent.new})
+	}
+	for _, c := range all {
+		for id, obj := range c.info.Defs {
+			add(id, obj)
+		}
+		for id, obj := range c.info.Uses {
+			add(id, obj)
+		}
+	}
+
+	for _, path := range w.goFiles {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		if len(edits[abs]) == 0 && !w.ToStdout {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		w.save(path, info, applyEdits(srcs[abs], edits[abs]), len(edits[abs]) > 0)
+	}
+}
+
+// parsePackages parses all the Go files in the directories of the walked Go
+// files, grouping them by package, and stores their content in srcs.
+func (w *walker) parsePackages(fset *token.FileSet, srcs map[string][]byte) []goPackage {
+	var (
+		dirs = make(map[string]bool)
+		mods = make(moduleCache)
+		pkgs []goPackage
+	)
+
+	for _, f := range w.goFiles {
+		dir, err := filepath.Abs(filepath.Dir(f))
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+
+		matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+
+		// The external test files form a package of their own.
+		byName := make(map[string]*goPackage)
+		var names []string
+		for _, m := range matches {
+			src, err := os.ReadFile(m)
+			if err != nil {
+				w.fail(err)
+				continue
+			}
+			file, err := parser.ParseFile(fset, m, src, parser.SkipObjectResolution)
+			if file == nil || file.Name == nil {
+				w.fail(err)
+				continue
+			}
+			srcs[m] = src
+
+			name := file.Name.Name
+			if byName[name] == nil {
+				byName[name] = &goPackage{dir: dir}
+				names = append(names, name)
+			}
+			byName[name].files = append(byName[name].files, file)
+		}
+
+		var ipath string
+		if mod := mods.lookup(dir); mod != nil {
+			ipath = mod.importPath(dir)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			p := byName[n]
+			if p.path = ipath; len(names) > 1 && strings.HasSuffix(n, "_test") {
+				p.path += "_test"
+			}
+			pkgs = append(pkgs, *p)
+		}
+	}
+	return pkgs
+}
+
+// collect adds to targets the positions of the declarations of g in p, if
+// p is the package of g when it is specified.
+// The top level declarations are looked up in the syntax, since only the
+// first of the ones repeated in files with different build constraints is
+// in the package scope.
+func (g goIdent) collect(targets map[posKey]bool, fset *token.FileSet, p goPackage, pkg *types.Package) error {
+	if g.pkg != "" && g.pkg != p.path {
+		return nil
+	}
+
+	var found bool
+	for _, f := range p.files {
+		for _, decl := range f.Decls {
+			for _, id := range g.declNames(decl) {
+				targets[keyOf(fset, id.Pos())] = true
+				found = true
+			}
+		}
+	}
+
+	if g.typ != "" && pkg != nil {
+		if tn, ok := pkg.Scope().Lookup(g.typ).(*types.TypeName); ok {
+			for _, obj := range members(tn.Type(), g.name) {
+				targets[keyOf(fset, obj.Pos())] = true
+				found = true
+			}
+		}
+	}
+
+	if !found || pkg == nil {
+		return nil
+	}
+	if g.conflicts(pkg) {
+		return fmt.Errorf("cannot rename %s to %s in %s: %s is already declared", g.old(), g.new, p.dir, g.new)
+	}
+	return nil
+}
+
+// declNames returns the names in decl that declare g: the package level
+// functions, types, variables and constants or the methods of g.typ.
+func (g goIdent) declNames(decl ast.Decl) (ids []*ast.Ident) {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Name.Name != g.name {
+			return nil
+		}
+		if d.Recv == nil && g.typ == "" || d.Recv != nil && g.typ != "" && recvName(d.Recv) == g.typ {
+			ids = append(ids, d.Name)
+		}
+
+	case *ast.GenDecl:
+		if g.typ != "" {
+			return nil
+		}
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				if s.Name.Name == g.name {
+					ids = append(ids, s.Name)
+				}
+			case *ast.ValueSpec:
+				for _, n := range s.Names {
+					if n.Name == g.name {
+						ids = append(ids, n)
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// conflicts reports whether renaming g would clash with an existing
+// declaration in pkg.
+func (g goIdent) conflicts(pkg *types.Package) bool {
+	if g.typ == "" {
+		return pkg.Scope().Lookup(g.new) != nil
+	}
+	tn, ok := pkg.Scope().Lookup(g.typ).(*types.TypeName)
+	return ok && len(members(tn.Type(), g.new)) > 0
+}
+
+// recvName returns the name of the base type of a method receiver.
+func recvName(recv *ast.FieldList) string {
+	if len(recv.List) == 0 {
+		return ""
+	}
+
+	t := recv.List[0].Type
+	for {
+		switch e := t.(type) {
+		case *ast.StarExpr:
+			t = e.X
+		case *ast.ParenExpr:
+			t = e.X
+		case *ast.IndexExpr:
+			t = e.X
+		case *ast.IndexListExpr:
+			t = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// members returns the methods, the struct fields and the interface methods
+// of typ with the given name.
+func members(typ types.Type, name string) (objs []types.Object) {
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return nil
+	}
+
+	for i := 0; i < named.NumMethods(); i++ {
+		if m := named.Method(i); m.Name() == name {
+			objs = append(objs, m)
+		}
+	}
+
+	switch u := named.Underlying().(type) {
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			if f := u.Field(i); f.Name() == name && !f.Embedded() {
+				objs = append(objs, f)
+			}
+		}
+	case *types.Interface:
+		for i := 0; i < u.NumExplicitMethods(); i++ {
+			if m := u.ExplicitMethod(i); m.Name() == name {
+				objs = append(objs, m)
+			}
+		}
+	}
+	return
+}
+
+// sourceImporter type checks the imported packages from source, resolving
+// them from the directory of the importing package, so that the ones of the
+// module being edited are found wherever jet is run from.
+type sourceImporter struct {
+	fset *token.FileSet
+	pkgs map[string]*types.Package
+}
+
+func newSourceImporter(fset *token.FileSet) *sourceImporter {
+	return &sourceImporter{fset: fset, pkgs: make(map[string]*types.Package)}
+}
+
+func (s *sourceImporter) Import(path string) (*types.Package, error) {
+	return s.ImportFrom(path, "", 0)
+}
+
+func (s *sourceImporter) ImportFrom(path, dir string, _ types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+
+	ctxt := build.Default
+	ctxt.Dir = dir
+	bp, err := ctxt.Import(path, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg, ok := s.pkgs[bp.Dir]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", path)
+		}
+		return pkg, nil
+	}
+	s.pkgs[bp.Dir] = nil
+
+	var files []*ast.File
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		f, err := parser.ParseFile(s.fset, filepath.Join(bp.Dir, name), nil, parser.SkipObjectResolution)
+		if f == nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	conf := types.Config{
+		Importer:         s,
+		IgnoreFuncBodies: true,
+		FakeImportC:      true,
+		Error:            func(error) {},
+	}
+	pkg, _ := conf.Check(bp.ImportPath, s.fset, files, nil)
+	s.pkgs[bp.Dir] = pkg
+	return pkg, nil
+}
+
+func keyOf(fset *token.FileSet, pos token.Pos) posKey {
+	p := fset.Position(pos)
+	return posKey{p.Filename, p.Offset}
+}
@@ -0,0 +1,181 @@
+package jet
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newGoModule creates a Go module in a temporary directory with the given
+// files and returns its path.
+func newGoModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	// The imported packages are type checked from source with the go command.
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not available")
+	}
+
+	files["go.mod"] = "module example.com/m\n\ngo 1.20\n"
+	return newTree(t, files)
+}
+
+func newGoIdentWalker(t *testing.T, old, name string) *walker {
+	t.Helper()
+
+	g, err := parseGoIdent(old, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &walker{
+		Options: Options{
+			Glob:     "*",
+			MaxDepth: -1,
+		},
+		WaitGroup: new(sync.WaitGroup),
+		goIdent:   g,
+	}
+}
+
+// TestWalkerRenameGoIdent tests that only the declaration and the uses of
+// the identifier are renamed, across packages.
+func TestWalkerRenameGoIdent(t *testing.T) {
+	root := newGoModule(t, map[string]string{
+		"a/a.go": `package a
+
+// Foo is a Foo.
+type Foo struct{ Name string }
+
+func (f Foo) String() string { return "Foo" }
+
+func New() Foo { return Foo{} }
+`,
+		"a/a_test.go": `package a_test
+
+import "example.com/m/a"
+
+var _ a.Foo = a.New()
+`,
+		"b/b.go": `package b
+
+import "example.com/m/a"
+
+func Use() string {
+	Foo := a.Foo{Name: "Foo"}
+	return Foo.String()
+}
+`,
+		"README": "Foo\n",
+	})
+
+	w := newGoIdentWalker(t, "Foo", "Bar")
+	output := captureStdout(func() {
+		w.Walk(root)
+	})
+	if output != "" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	checkTree(t, root, map[string]string{
+		"a/a.go": `package a
+
+// Foo is a Foo.
+type Bar struct{ Name string }
+
+func (f Bar) String() string { return "Foo" }
+
+func New() Bar { return Bar{} }
+`,
+		"a/a_test.go": `package a_test
+
+import "example.com/m/a"
+
+var _ a.Bar = a.New()
+`,
+		"b/b.go": `package b
+
+import "example.com/m/a"
+
+func Use() string {
+	Foo := a.Bar{Name: "Foo"}
+	return Foo.String()
+}
+`,
+		"README": "Foo\n",
+	})
+}
+
+// TestWalkerRenameGoIdent_Member tests renaming methods and fields, leaving
+// the ones of other types alone.
+func TestWalkerRenameGoIdent_Member(t *testing.T) {
+	root := newGoModule(t, map[string]string{
+		"a.go": `package m
+
+type T struct{ Name string }
+
+type U struct{ Name string }
+
+func (t *T) Get() string { return t.Name }
+
+func (u U) Get() string { return u.Name }
+
+var _ = T{Name: "x"}.Get() + U{Name: "y"}.Get()
+`,
+	})
+
+	w := newGoIdentWalker(t, "T.Name", "Title")
+	w.Walk(root)
+
+	w = newGoIdentWalker(t, "U.Get", "Fetch")
+	w.Walk(root)
+
+	checkTree(t, root, map[string]string{
+		"a.go": `package m
+
+type T struct{ Title string }
+
+type U struct{ Name string }
+
+func (t *T) Get() string { return t.Title }
+
+func (u U) Fetch() string { return u.Name }
+
+var _ = T{Title: "x"}.Get() + U{Name: "y"}.Fetch()
+`,
+	})
+}
+
+// TestWalkerRenameGoIdent_Conflict tests that nothing is renamed if the new
+// name is already declared.
+func TestWalkerRenameGoIdent_Conflict(t *testing.T) {
+	src := "package m\n\nfunc Foo() {}\n\nfunc Bar() { Foo() }\n"
+	root := newGoModule(t, map[string]string{"a.go": src})
+
+	w := newGoIdentWalker(t, "Foo", "Bar")
+	output := captureStdout(func() {
+		w.Walk(root)
+	})
+	if !strings.Contains(output, "Bar is already declared") {
+		t.Errorf("expected a conflict error, got %q", output)
+	}
+	checkTree(t, root, map[string]string{"a.go": src})
+}
+
+// TestWalkerRenameGoIdent_Package tests that an identifier qualified by its
+// import path is only renamed in that package and its uses.
+func TestWalkerRenameGoIdent_Package(t *testing.T) {
+	root := newGoModule(t, map[string]string{
+		"a/a.go": "package a\n\nfunc Foo() {}\n",
+		"b/b.go": "package b\n\nimport \"example.com/m/a\"\n\nfunc Foo() { a.Foo() }\n",
+	})
+
+	w := newGoIdentWalker(t, "example.com/m/a.Foo", "Bar")
+	w.Walk(filepath.Join(root, "a"), filepath.Join(root, "b"))
+
+	checkTree(t, root, map[string]string{
+		"a/a.go": "package a\n\nfunc Bar() {}\n",
+		"b/b.go": "package b\n\nimport \"example.com/m/a\"\n\nfunc Foo() { a.Bar() }\n",
+	})
+}
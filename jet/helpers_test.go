@@ -0,0 +1,41 @@
+package jet
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTree creates the given files, by their slash separated path, with their
+// content in a temporary directory and returns its path.
+func newTree(t testing.TB, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// checkTree checks that the given files under root have the given content.
+func checkTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, want := range files {
+		content, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Errorf("expected %s to exist: %v", name, err)
+			continue
+		}
+		if string(content) != want {
+			t.Errorf("unexpected content of %s:\n%s\nwant:\n%s", name, content, want)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package jet replaces the matches of regular expressions in the content
+// and in the names of files and directory trees. It is the engine of the
+// jet command, which is a thin wrapper over Run.
+package jet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Rule replaces the matches of Pattern with Replacement, where $1 or ${name}
+// are replaced by the submatches as in regexp.Regexp.Expand.
+type Rule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// NewRule returns the rule replacing the matches of pattern with
+// replacement.
+func NewRule(pattern, replacement string) (Rule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return Rule{Pattern: re, Replacement: replacement}, nil
+}
+
+// RuleSet is a list of rules applied in order, each one to the output of
+// the previous one.
+type RuleSet []Rule
+
+func (r RuleSet) String() string {
+	var buf strings.Builder
+
+	for i, rule := range r {
+		buf.WriteString(fmt.Sprintf(
+			"['%v', '%s']",
+			rule.Pattern,
+			rule.Replacement,
+		))
+
+		if i < len(r)-1 {
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.String()
+}
+
+// pairs returns the pairs applying the rules.
+func (r RuleSet) pairs() pairset {
+	if r == nil {
+		return nil
+	}
+	p := make(pairset, len(r))
+	for i, rule := range r {
+		p[i] = pair{pattern: rule.Pattern, replacement: []byte(rule.Replacement)}
+	}
+	return p
+}
+
+// Options are the options of Run. The zero value doesn't match any file
+// name, use DefaultOptions as a starting point.
+type Options struct {
+	// Rules are applied to the content of the files and, when renaming,
+	// to their names or paths.
+	Rules RuleSet
+	// ToStdout enables printing the edited files to Output instead of
+	// writing them.
+	ToStdout bool
+	// Glob is the pattern the names of the processed files must match.
+	Glob string
+	// IsVerbose enables explaining what is being done in Output.
+	IsVerbose bool
+	// MaxDepth is the maximum depth of the walked directories, unlimited
+	// if negative.
+	MaxDepth int
+	// IncludeHidden enables processing the files starting with a dot.
+	IncludeHidden bool
+	// ReplaceNames enables replacing the matches in the names of the files
+	// and the directories, NamesOnly disables editing their content.
+	ReplaceNames bool
+	NamesOnly    bool
+	// ReplacePaths enables applying the pairs to the paths of the files
+	// relative to the walked directory, moving them to the resulting path.
+	ReplacePaths bool
+	// UpdateRefs enables updating the references to the renamed files.
+	UpdateRefs bool
+	// LineMode enables applying the rules to each line separately.
+	LineMode bool
+	// MaxMatch is the maximum length in bytes of a match when streaming.
+	MaxMatch int
+	// FilesFrom is the file containing the list of paths to edit, "-" for
+	// stdin, separated by NUL characters if NulSeparated is set.
+	FilesFrom    string
+	NulSeparated bool
+	// Git enables processing only the files tracked by git, GitChanged
+	// restricts them to the ones with uncommitted changes.
+	Git        bool
+	GitChanged bool
+	// AllowDirty enables editing the files with uncommitted changes in
+	// their git repository.
+	AllowDirty bool
+	// KeepMtime enables preserving the modification time of the files.
+	KeepMtime bool
+	// FollowSymlinks enables descending into symbolic links to directories,
+	// NoFollow disables editing the files pointed by symbolic links.
+	FollowSymlinks bool
+	NoFollow       bool
+	// WithinRoots disables editing files outside of the walked paths.
+	WithinRoots bool
+	// OnlyComments, OnlyStrings and OnlyCode restrict the replacements to
+	// the comments, the string literals and the rest of the code.
+	OnlyComments bool
+	OnlyStrings  bool
+	OnlyCode     bool
+	// KeyPath restricts the replacements to the values at the given key
+	// path of the JSON, YAML and TOML files.
+	KeyPath string
+	// CSVColumns restricts the replacements to the cells of the given
+	// columns of the CSV and TSV files, by name or by index from 1.
+	CSVColumns   []string
+	CSVDelimiter string
+	CSVQuote     string
+	// Encoding is the encoding of the files, detected if empty.
+	Encoding string
+	// EOL is the line ending the files are converted to, lf or crlf, or
+	// preserve to keep the original ones.
+	EOL string
+	// Normalize is the Unicode normalization form the patterns and the
+	// files are matched in, NormalizeOutput enables writing the whole files
+	// in that form.
+	Normalize       string
+	NormalizeOutput bool
+	// Header is the format of the headers of the files printed with
+	// ToStdout, where %s is replaced by the path, NoHeader disables them.
+	Header   string
+	NoHeader bool
+	// ChangedOnly disables printing or writing the files whose content
+	// doesn't change.
+	ChangedOnly bool
+	// OutDir is the directory the edited files are written to, at their
+	// path relative to the walked directory, instead of in place.
+	// CopyUnchanged enables copying there the files that are not edited.
+	OutDir        string
+	CopyUnchanged bool
+	// StreamThreshold is the size in bytes above which files are streamed
+	// instead of being loaded in memory, a value <= 0 disables streaming.
+	StreamThreshold int64
+	// GoIdent is the Go identifier renamed to GoIdentNew, a package level
+	// name or a method or field in the form Type.Member, optionally
+	// prefixed by the import path of its package.
+	GoIdent    string
+	GoIdentNew string
+	// Output is where the edited files, the messages and the errors are
+	// printed, the standard output if nil.
+	Output io.Writer
+}
+
+// DefaultOptions returns the default options of the jet command.
+func DefaultOptions() Options {
+	return Options{
+		Glob:            "*",
+		MaxDepth:        -1,
+		MaxMatch:        defaultMaxMatch,
+		StreamThreshold: 64 << 20,
+		EOL:             eolPreserve,
+		CSVQuote:        `"`,
+	}
+}
+
+// Rename is a file or a directory renamed by Run.
+type Rename struct {
+	Old string
+	New string
+}
+
+// Report describes what Run has done.
+type Report struct {
+	// Written are the paths of the edited files that have been written,
+	// in place or to OutDir, in lexical order.
+	Written []string
+	// Renamed are the renames performed, in order. When writing to OutDir
+	// they are the ones of the files written there.
+	Renamed []Rename
+	// Errors are the errors met processing single files, which don't stop
+	// the run.
+	Errors []error
+}
+
+// recorder collects the report of a run from the files edited
+// concurrently. A nil recorder discards everything.
+type recorder struct {
+	mu     sync.Mutex
+	report Report
+}
+
+func (r *recorder) written(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Written = append(r.report.Written, path)
+}
+
+func (r *recorder) renamed(oldpath, newpath string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Renamed = append(r.report.Renamed, Rename{Old: oldpath, New: newpath})
+}
+
+func (r *recorder) failed(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Errors = append(r.report.Errors, err)
+}
+
+// An OptionError reports options that cannot be used together, naming them
+// as the fields of Options.
+type OptionError struct {
+	Msg string
+}
+
+func (e *OptionError) Error() string {
+	return e.Msg
+}
+
+// Run applies the rules in opts to the files under roots, where "-" stands
+// for stdin, and reports what it has done.
+// The errors of single files don't stop the run: they are printed to
+// opts.Output and listed in the report. The returned error is not nil if
+// the options are not valid, a *DirtyError if any file to modify has
+// uncommitted changes and AllowDirty is not set, an error if the renames
+// conflict, in which case no file is edited or renamed, or ctx.Err() if ctx
+// is done before the end of the run, in which case no file is renamed.
+func Run(ctx context.Context, opts Options, roots ...string) (Report, error) {
+	w, err := newWalker(opts, roots)
+	if err != nil {
+		return Report{}, err
+	}
+	// The list of files is read before the check, it can be read only
+	// once from stdin.
+	if w.FilesFrom != "" {
+		if w.listed, err = w.readFilesFrom(); err != nil {
+			return Report{}, err
+		}
+	}
+	// GitChanged only selects files with uncommitted changes, editing them
+	// is the point.
+	if !w.AllowDirty && !w.GitChanged {
+		if err := w.checkDirty(roots...); err != nil {
+			return Report{}, err
+		}
+	}
+
+	w.ctx = ctx
+	w.rec = new(recorder)
+	w.Walk(roots...)
+
+	// The files are written twice when their references are updated.
+	report := w.rec.report
+	sort.Strings(report.Written)
+	written := report.Written[:0]
+	for i, p := range report.Written {
+		if i == 0 || p != report.Written[i-1] {
+			written = append(written, p)
+		}
+	}
+	report.Written = written
+	if w.conflicted {
+		return report, errRenameConflicts
+	}
+	return report, ctx.Err()
+}
+
+// errRenameConflicts is returned by Run when the planned renames conflict.
+var errRenameConflicts = errors.New("rename conflicts found, no file has been edited or renamed")
+
+// newWalker returns the walker applying opts to roots, checking that the
+// options are valid.
+func newWalker(opts Options, roots []string) (*walker, error) {
+	w := &walker{
+		Options:   opts,
+		pairs:     opts.Rules.pairs(),
+		WaitGroup: new(sync.WaitGroup),
+	}
+	w.Git = w.Git || w.GitChanged
+
+	if w.GoIdent != "" || w.GoIdentNew != "" {
+		g, err := parseGoIdent(w.GoIdent, w.GoIdentNew)
+		if err != nil {
+			return nil, err
+		}
+		w.goIdent = g
+	}
+	if w.pairs == nil && !w.patternless() {
+		return nil, errors.New("no rule to apply")
+	}
+
+	if w.FollowSymlinks && w.NoFollow {
+		return nil, &OptionError{"FollowSymlinks and NoFollow are mutually exclusive"}
+	}
+	if w.LineMode && w.scoped() {
+		return nil, &OptionError{"OnlyComments, OnlyStrings and OnlyCode cannot be used with LineMode"}
+	}
+	if w.KeyPath != "" && (w.LineMode || w.scoped()) {
+		return nil, &OptionError{"KeyPath cannot be used with LineMode or the Only* options"}
+	}
+	if len(roots) > 1 && containsDash(roots) {
+		return nil, errors.New("cannot edit multiple files and stdin at the same time")
+	}
+	if len(w.CSVColumns) > 0 && (w.LineMode || w.scoped() || w.KeyPath != "") {
+		return nil, &OptionError{"CSVColumns cannot be used with LineMode, KeyPath or the Only* options"}
+	}
+	if w.Encoding != "" {
+		if _, err := lookupCodec(w.Encoding); err != nil {
+			return nil, err
+		}
+	}
+	if err := parseEOL(w.EOL); err != nil {
+		return nil, err
+	}
+	if form, err := parseNormForm(w.Normalize); err != nil {
+		return nil, err
+	} else if form != noNorm {
+		for i, p := range w.pairs {
+			if w.pairs[i], err = p.normalized(form); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if w.NormalizeOutput && (w.Normalize == "" || w.LineMode) {
+		return nil, &OptionError{"NormalizeOutput requires Normalize and cannot be used with LineMode"}
+	}
+	for _, d := range []string{w.CSVDelimiter, w.CSVQuote} {
+		if d == "" {
+			continue
+		}
+		if _, err := parseDelimiter(d); err != nil {
+			return nil, err
+		}
+	}
+	if w.KeyPath != "" && containsDash(roots) {
+		return nil, &OptionError{"cannot use KeyPath on stdin, the format of its content is not known"}
+	}
+	if w.FilesFrom == "-" && containsDash(roots) {
+		return nil, errors.New("cannot read both the list of files and the content from stdin")
+	}
+	if w.Header != "" && w.NoHeader {
+		return nil, &OptionError{"Header and NoHeader are mutually exclusive"}
+	}
+	if w.OutDir != "" && (w.ToStdout || containsDash(roots)) {
+		return nil, &OptionError{"OutDir cannot be used with ToStdout or stdin"}
+	}
+	if w.CopyUnchanged && w.OutDir == "" {
+		return nil, &OptionError{"CopyUnchanged requires OutDir"}
+	}
+	return w, nil
+}
+
+// fail prints err to the output and records it in the report.
+func (w *walker) fail(err error) {
+	fmt.Fprintln(w.stdout(), err)
+	w.rec.failed(err)
+}
+
+// done reports whether the context of the run is done, in which case no
+// other file is processed.
+func (w *walker) done() bool {
+	return w.ctx != nil && w.ctx.Err() != nil
+}
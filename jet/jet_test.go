@@ -0,0 +1,210 @@
+package jet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newOptions(pattern, replacement string) Options {
+	opts := DefaultOptions()
+	opts.Rules = RuleSet{{Pattern: regexp.MustCompile(pattern), Replacement: replacement}}
+	return opts
+}
+
+// TestRun tests that Run edits and renames the files and reports them.
+func TestRun(t *testing.T) {
+	root := newTree(t, map[string]string{
+		"foo.txt":   "foo\n",
+		"a/foo.txt": "baz\n",
+		"a/b.txt":   "foo foo\n",
+		"a/c.txt":   "baz\n",
+	})
+
+	var buf bytes.Buffer
+	opts := newOptions("foo", "bar")
+	opts.ReplaceNames = true
+	opts.Output = &buf
+
+	report, err := Run(context.Background(), opts, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+
+	checkTree(t, root, map[string]string{
+		"bar.txt":   "bar\n",
+		"a/bar.txt": "baz\n",
+		"a/b.txt":   "bar bar\n",
+		"a/c.txt":   "baz\n",
+	})
+
+	p := func(name string) string { return filepath.Join(root, name) }
+	expected := Report{
+		Written: []string{p("a/b.txt"), p("foo.txt")},
+		Renamed: []Rename{{Old: p("a/foo.txt"), New: p("a/bar.txt")}, {Old: p("foo.txt"), New: p("bar.txt")}},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Run() = %+v; want %+v", report, expected)
+	}
+}
+
+// TestRun_Output tests that the printed files and the errors go to Output
+// and that the errors of single files are reported without stopping the run.
+func TestRun_Output(t *testing.T) {
+	root := newTree(t, map[string]string{"a.txt": "foo\n"})
+
+	var buf bytes.Buffer
+	opts := newOptions("foo", "bar")
+	opts.ToStdout = true
+	opts.Output = &buf
+
+	missing := filepath.Join(root, "missing")
+	report, err := Run(context.Background(), opts, filepath.Join(root, "a.txt"), missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) != 1 || len(report.Written) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	expected := "==> " + filepath.Join(root, "a.txt") + " <==\nbar\n" + report.Errors[0].Error() + "\n"
+	if buf.String() != expected {
+		t.Errorf("output = %q; want %q", buf.String(), expected)
+	}
+	checkTree(t, root, map[string]string{"a.txt": "foo\n"})
+}
+
+// TestRun_Cancelled tests that nothing is edited or renamed once the context
+// is done.
+func TestRun_Cancelled(t *testing.T) {
+	files := map[string]string{"foo.txt": "foo\n", "a/foo.txt": "foo\n"}
+	root := newTree(t, files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	opts := newOptions("foo", "bar")
+	opts.ReplaceNames = true
+	report, err := Run(ctx, opts, root)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if len(report.Written) != 0 || len(report.Renamed) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	checkTree(t, root, files)
+}
+
+// TestRun_InvalidOptions tests that Run checks the options before editing
+// any file.
+func TestRun_InvalidOptions(t *testing.T) {
+	files := map[string]string{"a.txt": "foo\n"}
+	root := newTree(t, files)
+
+	tests := []struct {
+		name   string
+		modify func(o *Options)
+	}{
+		{"no rules", func(o *Options) { o.Rules = nil }},
+		{"symlinks", func(o *Options) { o.FollowSymlinks, o.NoFollow = true, true }},
+		{"line ending", func(o *Options) { o.EOL = "cr" }},
+		{"normalization", func(o *Options) { o.Normalize = "nfx" }},
+		{"headers", func(o *Options) { o.Header, o.NoHeader = "%s", true }},
+		{"copy unchanged", func(o *Options) { o.CopyUnchanged = true }},
+		{"go identifier", func(o *Options) { o.GoIdent, o.GoIdentNew = "a.b.c", "d" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := newOptions("foo", "bar")
+			test.modify(&opts)
+			if _, err := Run(context.Background(), opts, root); err == nil {
+				t.Errorf("expected an error")
+			}
+			checkTree(t, root, files)
+		})
+	}
+}
+
+// TestParseGoIdent tests the parsing of the identifiers to rename.
+func TestParseGoIdent(t *testing.T) {
+	tests := []struct {
+		old, name string
+		expected  goIdent
+		err       bool
+	}{
+		{"Old", "New", goIdent{name: "Old", new: "New"}, false},
+		{"T.Old", "New", goIdent{typ: "T", name: "Old", new: "New"}, false},
+		{"T.", "New", goIdent{}, true},
+		{"a.b.c", "New", goIdent{}, true},
+		{"example.com/m/a.Old", "New", goIdent{pkg: "example.com/m/a", name: "Old", new: "New"}, false},
+		{"example.com/m/a.T.Old", "New", goIdent{pkg: "example.com/m/a", typ: "T", name: "Old", new: "New"}, false},
+		{`"a".Old`, "New", goIdent{pkg: "a", name: "Old", new: "New"}, false},
+		{`"a"Old`, "New", goIdent{}, true},
+		{`"".Old`, "New", goIdent{}, true},
+		{"example.com/m", "New", goIdent{}, true},
+		{"Old", "1New", goIdent{}, true},
+	}
+
+	for _, test := range tests {
+		g, err := parseGoIdent(test.old, test.name)
+		if (err != nil) != test.err || g != test.expected {
+			t.Errorf("parseGoIdent(%q, %q) = %v, %v", test.old, test.name, g, err)
+		}
+	}
+}
+
+// TestRun_Dirty tests that the dirty check covers the files listed with
+// FilesFrom and the references updated with UpdateRefs, and that it is
+// skipped with GitChanged.
+func TestRun_Dirty(t *testing.T) {
+	dir := newGitRepo(t, map[string]string{
+		"a.txt":   "foo",
+		"foo.txt": "baz",
+		"ref.txt": "see foo.txt",
+	})
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("foo modified"), 0644)
+	os.WriteFile(filepath.Join(dir, "ref.txt"), []byte("see foo.txt again"), 0644)
+
+	list := filepath.Join(t.TempDir(), "list")
+	os.WriteFile(list, []byte(filepath.Join(dir, "a.txt")+"\n"), 0644)
+
+	opts := newOptions("foo", "bar")
+	opts.FilesFrom = list
+	opts.Output = io.Discard
+	if _, err := Run(context.Background(), opts, nil...); err == nil || !strings.Contains(err.Error(), "a.txt") {
+		t.Errorf("expected error about the listed a.txt, got %v", err)
+	}
+
+	opts = newOptions("foo", "bar")
+	opts.Glob = "*f*.txt"
+	opts.NamesOnly = true
+	opts.ReplaceNames = true
+	opts.UpdateRefs = true
+	opts.Output = io.Discard
+	if _, err := Run(context.Background(), opts, dir); err == nil || !strings.Contains(err.Error(), "ref.txt") {
+		t.Errorf("expected error about ref.txt, got %v", err)
+	}
+
+	opts = newOptions("foo", "bar")
+	opts.GitChanged = true
+	opts.Output = io.Discard
+	if _, err := Run(context.Background(), opts, dir); err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, dir, map[string]string{
+		"a.txt":   "bar modified",
+		"foo.txt": "baz",
+		"ref.txt": "see bar.txt again",
+	})
+}
@@ -0,0 +1,404 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+//go:generate go run gennorm.go
+
+package jet
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	resyntax "regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// normForm is a Unicode normalization form.
+type normForm int
+
+const (
+	noNorm normForm = iota
+	nfc
+	nfd
+	nfkc
+	nfkd
+)
+
+// Hangul syllables are composed and decomposed algorithmically.
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// composeSecond are the runes that can be composed with a previous starter.
+var composeSecond = func() map[rune]bool {
+	m := make(map[rune]bool)
+	for p := range composeTable {
+		m[p[1]] = true
+	}
+	return m
+}()
+
+// parseNormForm returns the normalization form with the given name.
+func parseNormForm(name string) (normForm, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return noNorm, nil
+	case "nfc":
+		return nfc, nil
+	case "nfd":
+		return nfd, nil
+	case "nfkc":
+		return nfkc, nil
+	case "nfkd":
+		return nfkd, nil
+	}
+	return noNorm, fmt.Errorf("invalid normalization form %q, expected nfc, nfd, nfkc or nfkd", name)
+}
+
+func (f normForm) compat() bool {
+	return f == nfkc || f == nfkd
+}
+
+func (f normForm) composes() bool {
+	return f == nfc || f == nfkc
+}
+
+func ccc(r rune) uint8 {
+	if r < 0x300 {
+		return 0
+	}
+	return cccTable[r]
+}
+
+// isSecond reports whether r can be composed with a previous starter.
+func isSecond(r rune) bool {
+	switch {
+	case r < 0x300:
+		return false
+	case r >= hangulVBase && r < hangulVBase+hangulVCount:
+		return true
+	case r > hangulTBase && r < hangulTBase+hangulTCount:
+		return true
+	}
+	return composeSecond[r]
+}
+
+// decomposition returns the full decomposition of r in f, or an empty
+// string if r is not decomposed.
+func (f normForm) decomposition(r rune) string {
+	if r < 0xA0 {
+		return ""
+	}
+	if s := r - hangulSBase; s >= 0 && s < hangulSCount {
+		d := []rune{hangulLBase + s/hangulNCount, hangulVBase + s%hangulNCount/hangulTCount}
+		if t := s % hangulTCount; t != 0 {
+			d = append(d, hangulTBase+t)
+		}
+		return string(d)
+	}
+	if f.compat() {
+		if d, ok := nfkdTable[r]; ok {
+			return d
+		}
+	}
+	return nfdTable[r]
+}
+
+// boundary reports whether the text can be split before r, that is if r
+// never interacts with the previous runes when normalizing.
+func (f normForm) boundary(r rune) bool {
+	if r < 0x300 {
+		return true
+	}
+	first := r
+	if d := f.decomposition(r); d != "" {
+		first, _ = utf8.DecodeRuneInString(d)
+	}
+	return ccc(r) == 0 && ccc(first) == 0 && !isSecond(r) && !isSecond(first)
+}
+
+// compose returns the primary composite of a and b, if any.
+func compose(a, b rune) (rune, bool) {
+	if l, v := a-hangulLBase, b-hangulVBase; l >= 0 && l < hangulLCount && v >= 0 && v < hangulVCount {
+		return hangulSBase + (l*hangulVCount+v)*hangulTCount, true
+	}
+	if s, t := a-hangulSBase, b-hangulTBase; s >= 0 && s < hangulSCount && s%hangulTCount == 0 && t > 0 && t < hangulTCount {
+		return a + t, true
+	}
+	c, ok := composeTable[[2]rune{a, b}]
+	return c, ok
+}
+
+// normalizeSegment returns the valid UTF-8 text b in the normalization
+// form f.
+func (f normForm) normalizeSegment(b []byte) []byte {
+	if len(b) == 1 && b[0] < utf8.RuneSelf {
+		return b
+	}
+
+	var rs []rune
+	for _, r := range string(b) {
+		if d := f.decomposition(r); d != "" {
+			rs = append(rs, []rune(d)...)
+		} else {
+			rs = append(rs, r)
+		}
+	}
+
+	// Sort the runs of non-starters by combining class, keeping the order
+	// of the runes with the same class.
+	for i := 1; i < len(rs); i++ {
+		for j := i; j > 0 && ccc(rs[j]) != 0 && ccc(rs[j-1]) > ccc(rs[j]); j-- {
+			rs[j], rs[j-1] = rs[j-1], rs[j]
+		}
+	}
+
+	if f.composes() && len(rs) > 1 {
+		var (
+			starter = 0
+			last    = int(ccc(rs[0]))
+			n       = 1
+		)
+		if last != 0 {
+			// Nothing composes with a leading non-starter.
+			last = 256
+		}
+		for _, r := range rs[1:] {
+			class := int(ccc(r))
+			if c, ok := compose(rs[starter], r); ok && (last < class || last == 0) {
+				rs[starter] = c
+				continue
+			}
+			if class == 0 {
+				starter = n
+			}
+			last = class
+			rs[n] = r
+			n++
+		}
+		rs = rs[:n]
+	}
+	return []byte(string(rs))
+}
+
+// segment is a part of a text that is normalized independently from the
+// rest, with its original and normalized content.
+type segment struct {
+	orig []byte
+	norm []byte
+}
+
+// segments splits b in segments, merging the consecutive ones that are not
+// changed by the normalization. The invalid UTF-8 bytes are left unchanged.
+func (f normForm) segments(b []byte) (segs []segment) {
+	// run is the start of the current run of unchanged segments.
+	var start, run int
+	flush := func(end int) {
+		if start == end {
+			return
+		}
+		seg := b[start:end]
+		norm := f.normalizeSegment(seg)
+		if bytes.Equal(norm, seg) {
+			return
+		}
+		if run < start {
+			segs = append(segs, segment{b[run:start], b[run:start]})
+		}
+		segs = append(segs, segment{seg, norm})
+		run = end
+	}
+
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		invalid := r == utf8.RuneError && size == 1
+		if invalid || f.boundary(r) {
+			flush(i)
+			start = i
+		}
+		i += size
+		if invalid {
+			start = i
+		}
+	}
+	flush(len(b))
+	if run < len(b) {
+		segs = append(segs, segment{b[run:], b[run:]})
+	}
+	return
+}
+
+// joinSegments returns the concatenation of the original or of the
+// normalized content of segs.
+func joinSegments(segs []segment, normalized bool) []byte {
+	var b []byte
+	for _, s := range segs {
+		if normalized {
+			b = append(b, s.norm...)
+		} else {
+			b = append(b, s.orig...)
+		}
+	}
+	return b
+}
+
+// isASCII reports whether b only contains ASCII characters, which are the
+// same in all the normalization forms.
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize returns b in the normalization form f.
+func (f normForm) normalize(b []byte) []byte {
+	if f == noNorm || isASCII(b) {
+		return b
+	}
+	return joinSegments(f.segments(b), true)
+}
+
+// normForm returns the normalization form set with --normalize.
+func (w *walker) normForm() normForm {
+	f, _ := parseNormForm(w.Normalize)
+	return f
+}
+
+// normalized returns p with its pattern and replacement in the form f, so
+// that it matches the normalized text.
+// Only the literal text of the pattern is normalized, so that its operators
+// keep their meaning.
+func (p pair) normalized(f normForm) (pair, error) {
+	tree, err := resyntax.Parse(p.pattern.String(), resyntax.Perl)
+	if err != nil {
+		return p, err
+	}
+	f.normalizeLiterals(tree)
+
+	re, err := regexp.Compile(tree.String())
+	if err != nil {
+		return p, fmt.Errorf("invalid normalized pattern: %w", err)
+	}
+	return pair{pattern: re, replacement: f.normalize(p.replacement), form: f}, nil
+}
+
+// normalizeLiterals normalizes the literals and the characters of the
+// classes in re. The characters of a class that are not a single character
+// in the form f become alternatives to the class.
+func (f normForm) normalizeLiterals(re *resyntax.Regexp) {
+	norm := func(r []rune) []rune {
+		return []rune(string(f.normalize([]byte(string(r)))))
+	}
+
+	switch re.Op {
+	case resyntax.OpLiteral:
+		re.Rune = norm(re.Rune)
+
+	case resyntax.OpCharClass:
+		var (
+			ranges []rune
+			alts   []*resyntax.Regexp
+		)
+		for i := 0; i < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			if lo != hi {
+				ranges = append(ranges, lo, hi)
+				continue
+			}
+			if n := norm([]rune{lo}); len(n) == 1 {
+				ranges = append(ranges, n[0], n[0])
+			} else {
+				alts = append(alts, &resyntax.Regexp{Op: resyntax.OpLiteral, Rune: n, Flags: re.Flags})
+			}
+		}
+		re.Rune = ranges
+		if len(alts) == 0 {
+			return
+		}
+		if len(ranges) > 0 {
+			alts = append(alts, &resyntax.Regexp{Op: resyntax.OpCharClass, Rune: ranges, Flags: re.Flags})
+		}
+		*re = resyntax.Regexp{Op: resyntax.OpAlternate, Sub: alts, Flags: re.Flags}
+	}
+
+	for _, sub := range re.Sub {
+		f.normalizeLiterals(sub)
+	}
+}
+
+// replaceSegments replaces the matches of p in the normalized content of
+// segs. The segments not touched by any match keep their original content,
+// the others are replaced by a single segment with the normalized content.
+func (p pair) replaceSegments(segs []segment) []segment {
+	text := joinSegments(segs, true)
+	matches := p.pattern.FindAllSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return segs
+	}
+
+	var (
+		out []segment
+		// pos is the offset in text of segs[i].
+		pos, i int
+	)
+	for m := 0; m < len(matches); {
+		// Keep the segments before the match.
+		for i < len(segs) && pos+len(segs[i].norm) <= matches[m][0] {
+			out = append(out, segs[i])
+			pos += len(segs[i].norm)
+			i++
+		}
+
+		// Replace the matches overlapping the same segments together.
+		var (
+			buf  []byte
+			last = pos
+		)
+		for {
+			match := matches[m]
+			buf = append(buf, text[last:match[0]]...)
+			buf = p.pattern.Expand(buf, p.replacement, text, match)
+			last = match[1]
+			m++
+
+			for i < len(segs) && pos < match[1] {
+				pos += len(segs[i].norm)
+				i++
+			}
+			if m == len(matches) || matches[m][0] >= pos {
+				break
+			}
+		}
+		if buf = append(buf, text[last:pos]...); len(buf) > 0 {
+			out = append(out, segment{buf, buf})
+		}
+	}
+	return append(out, segs[i:]...)
+}
@@ -0,0 +1,106 @@
+package jet
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// TestNormFormNormalize tests the normalize method of the normForm type.
+func TestNormFormNormalize(t *testing.T) {
+	tests := []struct {
+		form     normForm
+		input    string
+		expected string
+	}{
+		{nfc, "cafe\u0301", "caf\u00e9"},
+		{nfd, "caf\u00e9", "cafe\u0301"},
+		{nfc, "a\u0323\u0302", "\u1ead"},
+		{nfc, "a\u0302\u0323", "\u1ead"},
+		{nfd, "\u1ead", "a\u0323\u0302"},
+		{nfc, "\u1100\u1161\u11a8", "\uac01"},
+		{nfd, "\uac01", "\u1100\u1161\u11a8"},
+		{nfc, "\ufb01le", "\ufb01le"},
+		{nfkc, "\ufb01le", "file"},
+		{nfkd, "\u1e9b\u0323", "s\u0323\u0307"},
+		{nfkc, "\u1e9b\u0323", "\u1e69"},
+		{nfc, "\u0344", "\u0308\u0301"},
+		{nfc, "plain ascii", "plain ascii"},
+		{nfc, "e\xff\u0301", "e\xff\u0301"},
+	}
+
+	for _, test := range tests {
+		if got := string(test.form.normalize([]byte(test.input))); got != test.expected {
+			t.Errorf("normalize(%+q) in form %d = %+q; want %+q", test.input, test.form, got, test.expected)
+		}
+	}
+}
+
+// TestPairReplaceAll_Normalized tests that the normalized pairs match the
+// text in any form and that the text not matched keeps its original form.
+func TestPairReplaceAll_Normalized(t *testing.T) {
+	tests := []struct {
+		form        normForm
+		pattern     string
+		replacement string
+		input       string
+		expected    string
+	}{
+		{nfc, "caf\u00e9", "bar", "caf\u00e9 cafe\u0301 re\u0301sume\u0301", "bar bar re\u0301sume\u0301"},
+		{nfd, "caf\u00e9", "bar", "caf\u00e9 cafe\u0301 r\u00e9sum\u00e9", "bar bar r\u00e9sum\u00e9"},
+		{nfc, "sum", "SUM", "re\u0301sume\u0301", "re\u0301SUMe\u0301"},
+		{nfc, "e", "E", "cafe\u0301 e", "cafe\u0301 E"},
+		{nfd, "e", "E", "caf\u00e9", "cafE\u0301"},
+		{nfkc, "file", "doc", "\ufb01le file", "doc doc"},
+		{nfc, "x*", "-", "\u00e9", "-\u00e9-"},
+		{nfd, "[\u00e9]", "-", "e cafe\u0301", "e caf-"},
+		{nfd, "[a\u00e9]x", "-", "ax e\u0301x ex", "- - ex"},
+		{nfd, "caf\u00e9?", "-", "caf cafe cafe\u0301", "- -e -"},
+		{nfkc, "a\uff0a", "-", "a* aa", "- aa"},
+	}
+
+	for _, test := range tests {
+		p, err := pair{pattern: regexp.MustCompile(test.pattern), replacement: []byte(test.replacement)}.normalized(test.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(p.replaceAll([]byte(test.input))); got != test.expected {
+			t.Errorf("replaceAll(%+q) with %q in form %d = %+q; want %+q", test.input, test.pattern, test.form, got, test.expected)
+		}
+	}
+}
+
+// TestWalkerEdit_NormalizeOutput tests that the whole files are written in
+// the normalization form with --normalize-output, even without pairs.
+func TestWalkerEdit_NormalizeOutput(t *testing.T) {
+	tests := []struct {
+		pairs    pairset
+		input    string
+		expected string
+	}{
+		{nil, "cafe\u0301 caf\u00e9\n", "caf\u00e9 caf\u00e9\n"},
+		{pairset{{pattern: regexp.MustCompile("caf\u00e9"), replacement: []byte("the\u0301"), form: nfc}}, "cafe\u0301 re\u0301sume\u0301\n", "th\u00e9 r\u00e9sum\u00e9\n"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "file.txt")
+		if err := os.WriteFile(path, []byte(test.input), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		w := &walker{Options: Options{Normalize: "nfc", NormalizeOutput: true}, pairs: test.pairs}
+		if !w.rewrites(path) {
+			t.Fatalf("expected %s to be rewritten", path)
+		}
+		w.edit(path)
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != test.expected {
+			t.Errorf("edit(%+q) = %+q; want %+q", test.input, content, test.expected)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// errUnchanged is returned when the content of a file is not changed and
+// ChangedOnly is set, to leave it alone.
+var errUnchanged = errors.New("content not changed")
+
+// outPath returns the path in OutDir the file at path is written to, which
+// is its path relative to the first walked directory containing it. The
+// paths outside of them are kept inside OutDir.
+func (w *walker) outPath(path string) string {
+	rel := path
+	for _, root := range w.outRoots {
+		if r, err := filepath.Rel(root, path); err == nil && filepath.IsLocal(r) {
+			rel = r
+			break
+		}
+	}
+	rel = rel[len(filepath.VolumeName(rel)):]
+	return filepath.Join(w.OutDir, filepath.Clean(string(filepath.Separator)+rel))
+}
+
+// outFile returns the path in OutDir the file at path is written to,
+// creating its missing directories.
+func (w *walker) outFile(path string) (string, error) {
+	dst := w.outPath(path)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// mirror is the set of the files written to OutDir, by their original path.
+type mirror struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newMirror() *mirror {
+	return &mirror{paths: make(map[string]bool)}
+}
+
+// add records that the file at path has been written to OutDir.
+func (m *mirror) add(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paths[path] = true
+}
+
+// has reports whether the file at path has been written to OutDir.
+func (m *mirror) has(path string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paths[path]
+}
+
+// sorted returns the paths of the files written to OutDir in lexical order.
+func (m *mirror) sorted() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paths := make([]string, 0, len(m.paths))
+	for p := range m.paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// copies reports whether the file at path, renamed or not, has to be copied
+// to OutDir if it is not written there once edited.
+func (w *walker) copies(renamed bool) bool {
+	return w.OutDir != "" && (w.CopyUnchanged || renamed)
+}
+
+// copyOut copies the file at path to OutDir as it is, unless it has
+// already been written there. Symbolic links are copied as links.
+func (w *walker) copyOut(path string) {
+	if w.mirror.has(path) {
+		return
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	// Leave out the devices, the pipes and the sockets.
+	if !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+		return
+	}
+
+	dst, err := w.outFile(path)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	if w.IsVerbose {
+		fmt.Fprintf(w.stdout(), "copying %s to %s\n", path, dst)
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		err = copySymlink(dst, path)
+	} else {
+		err = copyFile(dst, path, info)
+		if err == nil {
+			err = w.restoreTimes(dst, info)
+		}
+	}
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	w.mirror.add(path)
+}
+
+// copyFile copies the content of the file at src described by info to the
+// file at dst, with the same permissions.
+func copyFile(dst, src string, info fs.FileInfo) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return createFile(dst, info.Mode().Perm(), func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
+
+// copySymlink creates at dst a symbolic link with the same target as the
+// one at src, replacing any existing file.
+func copySymlink(dst, src string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(target, dst)
+}
+
+// moveMirrored moves the files written to OutDir to the paths resulting from
+// renames, leaving the original files where they are.
+// It returns the renames whose files could not be moved.
+func (w *walker) moveMirrored(renames []rename) []rename {
+	moved := make(map[string]string, len(renames))
+	for _, r := range renames {
+		moved[r.old] = r.new
+	}
+
+	var (
+		moves   []rename
+		sources = make(map[string]string)
+	)
+	for _, path := range w.mirror.sorted() {
+		if newpath := w.renamedPath(moved, path); newpath != path {
+			moves = append(moves, rename{old: w.outPath(path), new: w.outPath(newpath)})
+			sources[w.outPath(path)] = path
+		}
+	}
+
+	var failed []rename
+	for _, m := range orderRenames(moves) {
+		if w.IsVerbose {
+			fmt.Fprintf(w.stdout(), "renaming %s to %s\n", m.old, m.new)
+		}
+		err := os.MkdirAll(filepath.Dir(m.new), 0755)
+		if err == nil {
+			err = os.Rename(m.old, m.new)
+		}
+		if err != nil {
+			w.fail(err)
+			// Every rename the file was moved by has failed.
+			for _, r := range renames {
+				if isInside(sources[m.old], r.old) {
+					failed = append(failed, r)
+				}
+			}
+			continue
+		}
+		w.rec.renamed(m.old, m.new)
+		removeEmptyDirs(filepath.Dir(m.old), w.OutDir)
+	}
+	return failed
+}
+
+// renamedPath returns path once the renames in moved, from the old paths to
+// the new ones, are performed, including the ones of its parent directories.
+func (w *walker) renamedPath(moved map[string]string, path string) string {
+	newpath, ok := moved[path]
+	// The files are moved to their whole new path.
+	if w.ReplacePaths {
+		if ok {
+			return newpath
+		}
+		return path
+	}
+
+	dir := filepath.Dir(path)
+	if dir != path {
+		dir = w.renamedPath(moved, dir)
+	}
+	if ok {
+		return filepath.Join(dir, filepath.Base(newpath))
+	}
+	return filepath.Join(dir, filepath.Base(path))
+}
+
+// printTemp prints the data written by fn, after the header of the file at
+// path, once fn succeeds. The data is buffered in a temporary file.
+func (w *walker) printTemp(path string, fn func(io.Writer) error) error {
+	tmp, err := os.CreateTemp("", "jet*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	bw := bufio.NewWriter(tmp)
+	if err := fn(bw); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.header(path)
+	_, err = io.Copy(w.stdout(), tmp)
+	return err
+}
+
+// compareWriter is an io.Writer comparing the data written to it with the
+// content of a file, to find whether an edit changes it.
+type compareWriter struct {
+	f    *os.File
+	buf  []byte
+	diff bool
+}
+
+func newCompareWriter(path string) (*compareWriter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &compareWriter{f: f}, nil
+}
+
+func (c *compareWriter) Write(p []byte) (int, error) {
+	if !c.diff {
+		if len(c.buf) < len(p) {
+			c.buf = make([]byte, len(p))
+		}
+		n, _ := io.ReadFull(c.f, c.buf[:len(p)])
+		c.diff = n < len(p) || !bytes.Equal(c.buf[:n], p)
+	}
+	return len(p), nil
+}
+
+// changed reports whether the data written differs from the content of the
+// file, once all of it has been written.
+func (c *compareWriter) changed() bool {
+	if !c.diff {
+		n, _ := c.f.Read(make([]byte, 1))
+		c.diff = n > 0
+	}
+	return c.diff
+}
+
+// Close closes the compared file.
+func (c *compareWriter) Close() error {
+	return c.f.Close()
+}
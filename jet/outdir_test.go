@@ -0,0 +1,333 @@
+package jet
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWalkerOutPath tests that the paths in OutDir are relative to the
+// walked directory and never outside of OutDir.
+func TestWalkerOutPath(t *testing.T) {
+	tests := []struct {
+		roots          []string
+		path, expected string
+	}{
+		{[]string{"src"}, "src/a/b.txt", "out/a/b.txt"},
+		{[]string{"src"}, "src/b.txt", "out/b.txt"},
+		{[]string{"."}, "b.txt", "out/b.txt"},
+		{[]string{"src", "src/a"}, "src/a/b.txt", "out/a/b.txt"},
+		{[]string{"lib", "src"}, "src/a/b.txt", "out/a/b.txt"},
+		{[]string{"src"}, "lib/b.txt", "out/lib/b.txt"},
+		{nil, "a/b.txt", "out/a/b.txt"},
+		{nil, "../a/b.txt", "out/a/b.txt"},
+		{nil, "/a/b.txt", "out/a/b.txt"},
+	}
+
+	for _, test := range tests {
+		w := &walker{Options: Options{OutDir: "out"}, outRoots: test.roots}
+		if p := w.outPath(filepath.FromSlash(test.path)); p != filepath.FromSlash(test.expected) {
+			t.Errorf("outPath(%q) with roots %q = %q; want %q", test.path, test.roots, p, test.expected)
+		}
+	}
+}
+
+// TestWalkerWalk_OutDir tests that the edited files are written to OutDir,
+// leaving the original ones untouched, and that OutDir is not walked when
+// it is inside the walked directory.
+func TestWalkerWalk_OutDir(t *testing.T) {
+	for _, threshold := range []int64{0, 1} {
+		files := map[string]string{
+			"a/foo.txt": "foo\n",
+			"b.txt":     "foo foo\n",
+			"c.txt":     "baz\n",
+		}
+		root := newTree(t, files)
+
+		w := newRenameWalker(pair{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")})
+		w.ReplaceNames = false
+		w.StreamThreshold = threshold
+		w.OutDir = filepath.Join(root, "out")
+
+		output := captureStdout(func() {
+			w.Walk(root)
+		})
+		if output != "" {
+			t.Errorf("threshold %d: unexpected output: %q", threshold, output)
+		}
+
+		checkTree(t, root, files)
+		checkTree(t, w.OutDir, map[string]string{
+			"a/foo.txt": "bar\n",
+			"b.txt":     "bar bar\n",
+		})
+		// The small streamed files are not checked by the prefilter.
+		if threshold == 0 && exists(filepath.Join(w.OutDir, "c.txt")) {
+			t.Errorf("threshold %d: expected c.txt without matches not to be written", threshold)
+		}
+		if exists(filepath.Join(w.OutDir, "out")) {
+			t.Errorf("threshold %d: expected the output directory not to be walked", threshold)
+		}
+	}
+}
+
+// TestWalkerWalk_OutDirSymlink tests that the targets of the symbolic links
+// are edited at their own paths in OutDir and the links copied as links.
+func TestWalkerWalk_OutDirSymlink(t *testing.T) {
+	for _, copyUnchanged := range []bool{false, true} {
+		root := newTree(t, map[string]string{"src/target.txt": "foo\n"})
+		src := filepath.Join(root, "src")
+		if err := os.Symlink("target.txt", filepath.Join(src, "alink")); err != nil {
+			t.Skip(err)
+		}
+
+		w := newRenameWalker(pair{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")})
+		w.ReplaceNames = false
+		w.CopyUnchanged = copyUnchanged
+		w.OutDir = filepath.Join(root, "out")
+		w.Walk(src)
+
+		checkTree(t, w.OutDir, map[string]string{"target.txt": "bar\n"})
+		info, err := os.Lstat(filepath.Join(w.OutDir, "alink"))
+		switch {
+		case !copyUnchanged && err == nil:
+			t.Errorf("expected the unchanged link not to be copied")
+		case copyUnchanged && (err != nil || info.Mode()&os.ModeSymlink == 0):
+			t.Errorf("expected the link to be copied as a link, got %v, %v", info, err)
+		}
+	}
+}
+
+// TestWalkerWalk_OutDirRenames tests that the renamed files are written to
+// their new paths in OutDir, copied if their content doesn't change, and
+// that the original files are neither edited nor renamed.
+func TestWalkerWalk_OutDirRenames(t *testing.T) {
+	files := map[string]string{
+		"foo/foo.txt": "foo\n",
+		"foo/a.txt":   "baz\n",
+		"b.txt":       "baz\n",
+		"foo.txt":     "qux\n",
+	}
+
+	tests := []struct {
+		name          string
+		replacePaths  bool
+		namesOnly     bool
+		copyUnchanged bool
+		expected      map[string]string
+		missing       []string
+	}{
+		{
+			name:     "names",
+			expected: map[string]string{"bar/bar.txt": "bar\n", "bar.txt": "qux\n"},
+			missing:  []string{"foo", "foo.txt", "bar/a.txt", "b.txt"},
+		},
+		{
+			name:          "copy unchanged",
+			copyUnchanged: true,
+			expected:      map[string]string{"bar/bar.txt": "bar\n", "bar/a.txt": "baz\n", "b.txt": "baz\n", "bar.txt": "qux\n"},
+			missing:       []string{"foo", "foo.txt"},
+		},
+		{
+			name:      "names only",
+			namesOnly: true,
+			expected:  map[string]string{"bar/bar.txt": "foo\n", "bar.txt": "qux\n"},
+			missing:   []string{"foo", "foo.txt", "b.txt"},
+		},
+		{
+			name:          "paths",
+			replacePaths:  true,
+			copyUnchanged: true,
+			expected:      map[string]string{"bar/bar.txt": "bar\n", "bar/a.txt": "baz\n", "b.txt": "baz\n", "bar.txt": "qux\n"},
+			missing:       []string{"foo", "foo.txt"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := newTree(t, files)
+
+			w := newRenameWalker(pair{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")})
+			w.ReplaceNames = !test.replacePaths
+			w.ReplacePaths = test.replacePaths
+			w.NamesOnly = test.namesOnly
+			w.CopyUnchanged = test.copyUnchanged
+			w.OutDir = filepath.Join(t.TempDir(), "out")
+
+			output := captureStdout(func() {
+				w.Walk(src)
+			})
+			if output != "" {
+				t.Errorf("unexpected output: %q", output)
+			}
+
+			checkTree(t, src, files)
+			checkTree(t, w.OutDir, test.expected)
+			for _, name := range test.missing {
+				if exists(filepath.Join(w.OutDir, name)) {
+					t.Errorf("expected %s not to be in the output directory", name)
+				}
+			}
+		})
+	}
+}
+
+// TestWalkerWalk_OutDirRefs tests that the references to the renamed files
+// are updated in the files written to OutDir.
+func TestWalkerWalk_OutDirRefs(t *testing.T) {
+	files := map[string]string{
+		"old_name.txt": "content\n",
+		"index.md":     "see old_name.txt\n",
+	}
+	src := newTree(t, files)
+
+	w := newRenameWalker(pair{pattern: regexp.MustCompile("old_name"), replacement: []byte("new_name")})
+	w.NamesOnly = true
+	w.UpdateRefs = true
+	w.OutDir = filepath.Join(t.TempDir(), "out")
+
+	output := captureStdout(func() {
+		w.Walk(src)
+	})
+	if output != "" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	checkTree(t, src, files)
+	checkTree(t, w.OutDir, map[string]string{
+		"new_name.txt": "content\n",
+		"index.md":     "see new_name.txt\n",
+	})
+}
+
+// TestWalkerWalk_ChangedOnly tests that only the changed files are printed
+// and that the unchanged ones are not written.
+func TestWalkerWalk_ChangedOnly(t *testing.T) {
+	for _, threshold := range []int64{0, 1} {
+		files := map[string]string{
+			"a.txt": "foo\n",
+			"b.txt": "baz\n",
+			"c.txt": "qux\n",
+		}
+		root := newTree(t, files)
+		old := time.Now().Add(-time.Hour).Truncate(time.Second)
+		for name := range files {
+			os.Chtimes(filepath.Join(root, name), old, old)
+		}
+
+		// "qux" is matched but replaced with itself.
+		newWalker := func(toStdout bool) *walker {
+			w := newRenameWalker(
+				pair{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+				pair{pattern: regexp.MustCompile("qux"), replacement: []byte("qux")},
+			)
+			w.ReplaceNames = false
+			w.StreamThreshold = threshold
+			w.ChangedOnly = true
+			w.ToStdout = toStdout
+			w.IsVerbose = !toStdout
+			return w
+		}
+
+		output := captureStdout(func() {
+			newWalker(true).Walk(root)
+		})
+		if expected := "==> " + filepath.Join(root, "a.txt") + " <==\nbar\n"; output != expected {
+			t.Errorf("threshold %d: -p output = %q; want %q", threshold, output, expected)
+		}
+
+		output = captureStdout(func() {
+			newWalker(false).Walk(root)
+		})
+		if expected := "writing " + filepath.Join(root, "a.txt") + "\n"; output != expected {
+			t.Errorf("threshold %d: -v output = %q; want %q", threshold, output, expected)
+		}
+		checkTree(t, root, map[string]string{"a.txt": "bar\n", "b.txt": "baz\n", "c.txt": "qux\n"})
+		if info, err := os.Stat(filepath.Join(root, "c.txt")); err != nil || !info.ModTime().Equal(old) {
+			t.Errorf("threshold %d: expected the unchanged c.txt not to be written", threshold)
+		}
+	}
+}
+
+// TestWalkerWalk_Header tests the format of the headers and that they can
+// be forced or disabled.
+func TestWalkerWalk_Header(t *testing.T) {
+	root := newTree(t, map[string]string{"a.txt": "foo\n", "b.txt": "foo\n"})
+	a, b := filepath.Join(root, "a.txt"), filepath.Join(root, "b.txt")
+
+	tests := []struct {
+		name     string
+		header   string
+		noHeader bool
+		paths    []string
+		expected string
+	}{
+		{"single file", "", false, []string{a}, "bar\n"},
+		{"forced", "--- %s", false, []string{a}, "--- " + a + "\nbar\n"},
+		{"format", "[%s] %s", false, []string{a, b}, "[" + a + "] " + a + "\nbar\n[" + b + "] " + b + "\nbar\n"},
+		{"disabled", "", true, []string{root}, "bar\nbar\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newRenameWalker(pair{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")})
+			w.ReplaceNames = false
+			w.ToStdout = true
+			w.Header = test.header
+			w.NoHeader = test.noHeader
+
+			output := captureStdout(func() {
+				w.Walk(test.paths...)
+			})
+			if output != test.expected {
+				t.Errorf("output = %q; want %q", output, test.expected)
+			}
+		})
+	}
+}
+
+// TestCompareWriter tests that compareWriter detects any difference with the
+// content of the file, whatever the size of the writes.
+func TestCompareWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	content := strings.Repeat("abcdefgh", 1000)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		changed bool
+	}{
+		{"same", content, false},
+		{"different", content[:4000] + "x" + content[4001:], true},
+		{"shorter", content[:len(content)-1], true},
+		{"longer", content + "a", true},
+		{"empty", "", true},
+	}
+
+	for _, test := range tests {
+		for _, size := range []int{1, 7, 4096, len(content) + 1} {
+			c, err := newCompareWriter(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for data := test.data; len(data) > 0; {
+				n := size
+				if n > len(data) {
+					n = len(data)
+				}
+				c.Write([]byte(data[:n]))
+				data = data[n:]
+			}
+			if changed := c.changed(); changed != test.changed {
+				t.Errorf("%s with writes of %d bytes: changed() = %v; want %v", test.name, size, changed, test.changed)
+			}
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// output writes the output of the files edited concurrently in the order
+// they have been walked. Each file gets a slot, the output written to the
+// first pending slot goes straight to out while the one written to the
+// following slots is buffered until all the previous ones are released.
+// The output written to output itself follows the one of the pending slots.
+type output struct {
+	out     io.Writer
+	mu      sync.Mutex
+	pending []*slot
+}
+
+// slot is the output of a single file.
+type slot struct {
+	o        *output
+	buf      bytes.Buffer
+	released bool
+}
+
+func newOutput(out io.Writer) *output {
+	return &output{out: out}
+}
+
+// reserve returns a new slot after the pending ones.
+func (o *output) reserve() *slot {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	s := &slot{o: o}
+	o.pending = append(o.pending, s)
+	return s
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.pending) == 0 {
+		return o.out.Write(p)
+	}
+	s := &slot{o: o, released: true}
+	s.buf.Write(p)
+	o.pending = append(o.pending, s)
+	return len(p), nil
+}
+
+// flush writes out the released slots at the head of the queue and the
+// buffered output of the first pending one.
+// It must be called with the lock held.
+func (o *output) flush() error {
+	for len(o.pending) > 0 {
+		s := o.pending[0]
+		if _, err := s.buf.WriteTo(o.out); err != nil {
+			return err
+		}
+		if !s.released {
+			return nil
+		}
+		o.pending = o.pending[1:]
+	}
+	return nil
+}
+
+func (s *slot) Write(p []byte) (int, error) {
+	s.o.mu.Lock()
+	defer s.o.mu.Unlock()
+
+	if s.o.pending[0] != s {
+		return s.buf.Write(p)
+	}
+	if err := s.o.flush(); err != nil {
+		return 0, err
+	}
+	return s.o.out.Write(p)
+}
+
+// release marks the output of the slot as complete.
+func (s *slot) release() {
+	s.o.mu.Lock()
+	defer s.o.mu.Unlock()
+
+	s.released = true
+	if err := s.o.flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// stdout returns the writer of the output of w, Output or the standard
+// output by default.
+func (w *walker) stdout() io.Writer {
+	if w.out != nil {
+		return w.out
+	}
+	if w.Output != nil {
+		return w.Output
+	}
+	return os.Stdout
+}
+
+// withOutput returns a copy of w writing its output to out, to edit a file
+// concurrently with the others.
+func (w *walker) withOutput(out io.Writer) *walker {
+	c := *w
+	c.out = out
+	return &c
+}
+
+// reserve returns a slot of the output of w for a file edited concurrently
+// with the others.
+func (w *walker) reserve() *slot {
+	o, ok := w.out.(*output)
+	if !ok {
+		o = newOutput(w.stdout())
+		w.out = o
+	}
+	return o.reserve()
+}
+
+// manyFiles reports whether the content of more than one file might be
+// printed when walking paths.
+func (w *walker) manyFiles(paths []string) bool {
+	if w.FilesFrom != "" || len(paths) > 1 {
+		return true
+	}
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultHeader is the format of the headers if Header is not set.
+const defaultHeader = "==> %s <=="
+
+// header writes the header preceding the content of the file at path when
+// printing the content of many files to stdout, or when Header is set.
+func (w *walker) header(path string) {
+	if !w.headers {
+		return
+	}
+	format := w.Header
+	if format == "" {
+		format = defaultHeader
+	}
+	fmt.Fprintln(w.stdout(), strings.ReplaceAll(format, "%s", path))
+}
@@ -0,0 +1,130 @@
+package jet
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestOutput tests that the output of the slots is written in their order,
+// whatever the order in which they are written and released.
+func TestOutput(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		o     = newOutput(&buf)
+		slots []*slot
+	)
+	for i := 0; i < 3; i++ {
+		slots = append(slots, o.reserve())
+	}
+	fmt.Fprint(o, "main\n")
+	slots = append(slots, o.reserve())
+
+	var wg sync.WaitGroup
+	for i := len(slots) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fmt.Fprintf(slots[i], "slot %d, ", i)
+			fmt.Fprintf(slots[i], "line %d\n", i)
+		}(i)
+	}
+	wg.Wait()
+
+	// Only the first slot is written right away.
+	if buf.String() != "slot 0, line 0\n" {
+		t.Errorf("output before the releases = %q; want %q", buf.String(), "slot 0, line 0\n")
+	}
+	for i := len(slots) - 1; i >= 0; i-- {
+		slots[i].release()
+	}
+
+	expected := "slot 0, line 0\nslot 1, line 1\nslot 2, line 2\nmain\nslot 3, line 3\n"
+	if buf.String() != expected {
+		t.Errorf("output = %q; want %q", buf.String(), expected)
+	}
+}
+
+// TestOutput_FirstSlot tests that the output of the first pending slot is
+// written right away.
+func TestOutput_FirstSlot(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		o      = newOutput(&buf)
+		first  = o.reserve()
+		second = o.reserve()
+	)
+	fmt.Fprint(second, "second\n")
+	fmt.Fprint(first, "first\n")
+	if buf.String() != "first\n" {
+		t.Errorf("output = %q; want %q", buf.String(), "first\n")
+	}
+
+	first.release()
+	if buf.String() != "first\nsecond\n" {
+		t.Errorf("output = %q; want %q", buf.String(), "first\nsecond\n")
+	}
+	fmt.Fprint(second, "more\n")
+	second.release()
+	if buf.String() != "first\nsecond\nmore\n" {
+		t.Errorf("output = %q; want %q", buf.String(), "first\nsecond\nmore\n")
+	}
+}
+
+// TestWalkerWalk_OrderedOutput tests that the content printed with -p and
+// the verbose messages follow the walk order, with the headers of the files
+// when printing many of them.
+func TestWalkerWalk_OrderedOutput(t *testing.T) {
+	var names []string
+	files := make(map[string]string)
+	for _, d := range []string{"a", "b"} {
+		for i := 0; i < 20; i++ {
+			name := filepath.Join(d, fmt.Sprintf("f%02d.txt", i))
+			names = append(names, name)
+			files[name] = fmt.Sprintf("foo %s\n", name)
+		}
+	}
+	dir := newTree(t, files)
+
+	var expected, verbose strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&expected, "==> %s <==\nbar %s\n", filepath.Join(dir, name), name)
+		fmt.Fprintf(&verbose, "writing %s\n", filepath.Join(dir, name))
+	}
+
+	newWalker := func(toStdout bool) *walker {
+		w := newRenameWalker(pair{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")})
+		w.ReplaceNames = false
+		w.ToStdout = toStdout
+		w.IsVerbose = !toStdout
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		output := captureStdout(func() {
+			newWalker(true).Walk(dir)
+		})
+		if output != expected.String() {
+			t.Fatalf("-p output =\n%s\nwant\n%s", output, expected.String())
+		}
+	}
+
+	single := filepath.Join(dir, "a", "f00.txt")
+	output := captureStdout(func() {
+		newWalker(true).Walk(single)
+	})
+	if expected := "bar a/f00.txt\n"; output != expected {
+		t.Errorf("-p output of a single file = %q; want %q", output, expected)
+	}
+
+	output = captureStdout(func() {
+		newWalker(false).Walk(dir)
+	})
+	if output != verbose.String() {
+		t.Errorf("-v output =\n%s\nwant\n%s", output, verbose.String())
+	}
+}
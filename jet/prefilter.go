@@ -0,0 +1,368 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	resyntax "regexp/syntax"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
+)
+
+// mmapThreshold is the size in bytes above which the files are memory mapped
+// to be checked by the prefilter, instead of being read.
+const mmapThreshold = 256 << 10
+
+// maxLiterals is the maximum number of literals combined from the parts of
+// a concatenation and of characters of a class turned into literals, e.g.
+// [ab]c requires either "ac" or "bc".
+const maxLiterals = 16
+
+// requiredLiterals returns a set of literals such that any match of re
+// contains at least one of them, or nil if there is no such set.
+func requiredLiterals(re *resyntax.Regexp) []string {
+	lits, _ := literals(re)
+	return lits
+}
+
+// literals is like requiredLiterals but it also reports whether the matches
+// of re are exactly the literals, so that they can be combined with the
+// ones of the adjacent expressions.
+func literals(re *resyntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case resyntax.OpLiteral:
+		if re.Flags&resyntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+
+	case resyntax.OpCharClass:
+		var lits []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if len(lits) == maxLiterals {
+					return nil, false
+				}
+				lits = append(lits, string(r))
+			}
+		}
+		return lits, true
+
+	case resyntax.OpEmptyMatch, resyntax.OpBeginLine, resyntax.OpEndLine,
+		resyntax.OpBeginText, resyntax.OpEndText, resyntax.OpWordBoundary,
+		resyntax.OpNoWordBoundary:
+		return []string{""}, true
+
+	case resyntax.OpCapture:
+		return literals(re.Sub[0])
+
+	case resyntax.OpPlus:
+		lits, _ := literals(re.Sub[0])
+		return lits, false
+
+	case resyntax.OpRepeat:
+		if re.Min > 0 {
+			lits, _ := literals(re.Sub[0])
+			return lits, false
+		}
+
+	case resyntax.OpConcat:
+		// Combine the runs of exact parts and pick the most selective set.
+		var (
+			best  []string
+			run   []string
+			exact = true
+		)
+		for _, sub := range re.Sub {
+			lits, ok := literals(sub)
+			switch {
+			case ok && run != nil && len(run)*len(lits) <= maxLiterals:
+				run = concatLiterals(run, lits)
+				continue
+			case ok && run != nil:
+				exact = false
+			case !ok:
+				exact = false
+			}
+			if betterLiterals(run, best) {
+				best = run
+			}
+			run = nil
+			if ok {
+				run = lits
+			} else if betterLiterals(lits, best) {
+				best = lits
+			}
+		}
+		if betterLiterals(run, best) {
+			best = run
+		}
+		return best, exact && len(re.Sub) > 0
+
+	case resyntax.OpAlternate:
+		var (
+			lits  []string
+			exact = true
+		)
+		for _, sub := range re.Sub {
+			l, ok := literals(sub)
+			if l == nil {
+				return nil, false
+			}
+			lits = append(lits, l...)
+			exact = exact && ok
+		}
+		return lits, exact
+	}
+	return nil, false
+}
+
+// concatLiterals returns all the concatenations of a literal of a with one
+// of b.
+func concatLiterals(a, b []string) []string {
+	lits := make([]string, 0, len(a)*len(b))
+	for _, x := range a {
+		for _, y := range b {
+			lits = append(lits, x+y)
+		}
+	}
+	return lits
+}
+
+// betterLiterals reports whether the set of literals a is more selective
+// than b, preferring longer literals and then fewer of them.
+func betterLiterals(a, b []string) bool {
+	switch {
+	case a == nil:
+		return false
+	case b == nil:
+		return true
+	}
+
+	minLen := func(lits []string) int {
+		n := len(lits[0])
+		for _, l := range lits[1:] {
+			if len(l) < n {
+				n = len(l)
+			}
+		}
+		return n
+	}
+	if na, nb := minLen(a), minLen(b); na != nb {
+		return na > nb
+	}
+	return len(a) < len(b)
+}
+
+// prefilter rejects the texts that can't contain any match of a pairset,
+// since they contain none of the literals required by its patterns, without
+// running the regular expressions.
+// A nil prefilter accepts any text.
+type prefilter struct {
+	// literal is the only required literal, searched with bytes.Index.
+	literal []byte
+	ac      *ahoCorasick
+	// newline reports whether any literal contains a line ending, which
+	// could only be found once the CRLF line endings are normalized.
+	newline bool
+}
+
+// newPrefilter returns the prefilter of pairs, or nil if any of the patterns
+// has no required literal.
+func newPrefilter(pairs pairset) *prefilter {
+	var (
+		lits []string
+		seen = make(map[string]bool)
+	)
+	for _, p := range pairs {
+		// The normalized pairs match the text in a different form.
+		if p.form != noNorm {
+			return nil
+		}
+		re, err := resyntax.Parse(p.pattern.String(), resyntax.Perl)
+		if err != nil {
+			return nil
+		}
+		l := requiredLiterals(re.Simplify())
+		if l == nil {
+			return nil
+		}
+		for _, s := range l {
+			// The pattern might only match the empty string.
+			if s == "" {
+				return nil
+			}
+			if !seen[s] {
+				seen[s] = true
+				lits = append(lits, s)
+			}
+		}
+	}
+
+	var p prefilter
+	switch len(lits) {
+	case 0:
+		return nil
+	case 1:
+		p.literal = []byte(lits[0])
+	default:
+		p.ac = newAhoCorasick(lits)
+	}
+	for _, l := range lits {
+		p.newline = p.newline || strings.ContainsAny(l, "\r\n")
+	}
+	return &p
+}
+
+// match reports whether b might contain a match.
+func (p *prefilter) match(b []byte) bool {
+	switch {
+	case p == nil:
+		return true
+	case p.ac != nil:
+		return p.ac.match(b)
+	}
+	return bytes.Contains(b, p.literal)
+}
+
+// skippable reports whether the file at path described by info can't contain
+// any match, checking its content mapped in memory instead of reading it.
+// Only the large regular UTF-8 files are checked, the others are read as
+// usual.
+func (w *walker) skippable(path string, info fs.FileInfo) (skip bool) {
+	if w.filter == nil || w.filter.newline || (w.ToStdout && !w.ChangedOnly) || w.convertsEOL() || w.NormalizeOutput {
+		return false
+	}
+	if !info.Mode().IsRegular() || info.Size() < mmapThreshold || int64(int(info.Size())) != info.Size() {
+		return false
+	}
+	if w.Encoding != "" {
+		if c, err := lookupCodec(w.Encoding); err != nil || c != utf8Codec {
+			return false
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	b, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return false
+	}
+	defer unmapFile(b)
+
+	// Accessing the memory of a file truncated in the meantime faults.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if recover() != nil {
+			skip = false
+		}
+	}()
+
+	// The literals are searched in the content as it is, so it must not
+	// need any conversion.
+	if c, _ := detectWide(b); c != nil && c != utf8Codec || !utf8.Valid(b) {
+		return false
+	}
+	return !w.filter.match(b)
+}
+
+// ahoCorasick is an automaton finding any of a set of literals in a single
+// pass over the text.
+type ahoCorasick struct {
+	// delta is the transition table, with 256 entries per state.
+	delta []int32
+	// out reports whether the states end one of the literals.
+	out []bool
+}
+
+func newAhoCorasick(lits []string) *ahoCorasick {
+	ac := &ahoCorasick{}
+	addState := func() int32 {
+		for i := 0; i < 256; i++ {
+			ac.delta = append(ac.delta, -1)
+		}
+		ac.out = append(ac.out, false)
+		return int32(len(ac.out) - 1)
+	}
+	addState()
+
+	// Build the trie of the literals.
+	for _, lit := range lits {
+		var s int32
+		for i := 0; i < len(lit); i++ {
+			t := int(s)<<8 | int(lit[i])
+			if ac.delta[t] < 0 {
+				// addState grows delta, so it must be called first.
+				n := addState()
+				ac.delta[t] = n
+			}
+			s = ac.delta[t]
+		}
+		ac.out[s] = true
+	}
+
+	// Compute the failure links breadth first, replacing the missing
+	// transitions with the ones of the failure state.
+	var (
+		fail  = make([]int32, len(ac.out))
+		queue []int32
+	)
+	for c := 0; c < 256; c++ {
+		if t := ac.delta[c]; t < 0 {
+			ac.delta[c] = 0
+		} else {
+			queue = append(queue, t)
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		ac.out[s] = ac.out[s] || ac.out[fail[s]]
+
+		for c := 0; c < 256; c++ {
+			i, f := int(s)<<8|c, int(fail[s])<<8|c
+			if t := ac.delta[i]; t < 0 {
+				ac.delta[i] = ac.delta[f]
+			} else {
+				fail[t] = ac.delta[f]
+				queue = append(queue, t)
+			}
+		}
+	}
+	return ac
+}
+
+// match reports whether b contains any of the literals.
+func (ac *ahoCorasick) match(b []byte) bool {
+	var s int32
+	for _, c := range b {
+		s = ac.delta[int(s)<<8|int(c)]
+		if ac.out[s] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package jet
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	resyntax "regexp/syntax"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRequiredLiterals tests the requiredLiterals function.
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{`foo`, []string{"foo"}},
+		{`foo\d+barbaz`, []string{"barbaz"}},
+		{`(foo|bar)s?`, []string{"foo", "bar"}},
+		{`[ab]cd*`, []string{"ac", "bc"}},
+		{`x[ab]`, []string{"xa", "xb"}},
+		{`(?m)^(zip|zap)\d+$`, []string{"zip", "zap"}},
+		{`foo|fob`, []string{"fob", "foo"}},
+		{`[ab]`, []string{"a", "b"}},
+		{`(?:hello){2,}`, []string{"hello"}},
+		{`\bword\b`, []string{"word"}},
+		{`(?i)foo`, nil},
+		{`foo|\w+`, nil},
+		{`foo|\d+`, []string{"foo", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}},
+		{`a*`, nil},
+		{`[a-z]+`, nil},
+		{`.`, nil},
+	}
+
+	for _, test := range tests {
+		re, err := resyntax.Parse(test.pattern, resyntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lits := requiredLiterals(re.Simplify()); !equalStrings(lits, test.expected) {
+			t.Errorf("requiredLiterals(%q) = %q; want %q", test.pattern, lits, test.expected)
+		}
+	}
+}
+
+// TestPrefilterMatch tests that the prefilter accepts the texts containing
+// any of the required literals and only them.
+func TestPrefilterMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		input    string
+		expected bool
+	}{
+		{[]string{"foo"}, "a foo b", true},
+		{[]string{"foo"}, "a fo o", false},
+		{[]string{"he", "she|his|hers"}, "ushers", true},
+		{[]string{"hers", "his"}, "ushe", false},
+		{[]string{"abcd", "bc"}, "xabcx", true},
+		{[]string{"abcd", "bcx"}, "abcbcx", true},
+		{[]string{"abcd", "bcx"}, "abcbc", false},
+		{[]string{"café", "naïve"}, "un café", true},
+		{[]string{"foo", `\w+`}, "anything", true},
+		{[]string{"^$", "foo"}, "", true},
+	}
+
+	for _, test := range tests {
+		var pairs pairset
+		for _, p := range test.patterns {
+			pairs = append(pairs, pair{pattern: regexp.MustCompile(p)})
+		}
+		if got := newPrefilter(pairs).match([]byte(test.input)); got != test.expected {
+			t.Errorf("prefilter %q match(%q) = %t; want %t", test.patterns, test.input, got, test.expected)
+		}
+	}
+}
+
+// TestWalkerWalk_Prefilter tests that the files without any match are not
+// rewritten.
+func TestWalkerWalk_Prefilter(t *testing.T) {
+	dir := newTree(t, map[string]string{
+		"match.txt":    "foo bar\n",
+		"nomatch.txt":  "bar baz\n",
+		"crlf.txt":     "foo\r\n",
+		"nomatch2.txt": "f\no\no\n",
+	})
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"match.txt", "nomatch.txt", "crlf.txt", "nomatch2.txt"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := newRenameWalker(
+		pair{pattern: regexp.MustCompile(`foo|qux`), replacement: []byte("FOO")},
+		pair{pattern: regexp.MustCompile(`(?m)O$`), replacement: []byte("0")},
+	)
+	w.ReplaceNames = false
+	w.Walk(dir)
+
+	checkTree(t, dir, map[string]string{
+		"match.txt":    "FOO bar\n",
+		"nomatch.txt":  "bar baz\n",
+		"crlf.txt":     "FO0\r\n",
+		"nomatch2.txt": "f\no\no\n",
+	})
+	for _, name := range []string{"nomatch.txt", "nomatch2.txt"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(old) {
+			t.Errorf("expected %s not to be rewritten", name)
+		}
+	}
+}
+
+// TestWalkerWalk_PrefilterUnescaped tests that the values at KeyPath and
+// the CSV cells are matched unescaped, even if the escaped text has no match.
+func TestWalkerWalk_PrefilterUnescaped(t *testing.T) {
+	dir := newTree(t, map[string]string{
+		"a.json": `{"arr": [{"image": "a\"b"}]}`,
+		"b.csv":  "id,desc\n1,\"say \"\"hi\"\"\"\n",
+	})
+
+	w := newRenameWalker(pair{pattern: regexp.MustCompile(`a"b`), replacement: []byte("X")})
+	w.ReplaceNames = false
+	w.KeyPath = "arr[0].image"
+	w.Walk(filepath.Join(dir, "a.json"))
+
+	w = newRenameWalker(pair{pattern: regexp.MustCompile(`y "h`), replacement: []byte("y h")})
+	w.ReplaceNames = false
+	w.CSVColumns = []string{"desc"}
+	w.CSVQuote = `"`
+	w.Walk(filepath.Join(dir, "b.csv"))
+
+	checkTree(t, dir, map[string]string{
+		"a.json": `{"arr": [{"image": "X"}]}`,
+		"b.csv":  "id,desc\n1,\"say hi\"\"\"\n",
+	})
+}
+
+// syntheticTree returns the contents of n files of the given size made of
+// random words, one in every hundred containing the word "needle".
+func syntheticTree(n, size int) [][]byte {
+	var (
+		rng   = rand.New(rand.NewSource(1))
+		words = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua")
+		files = make([][]byte, n)
+	)
+	for i := range files {
+		var sb strings.Builder
+		for sb.Len() < size {
+			sb.WriteString(words[rng.Intn(len(words))])
+			if rng.Intn(12) == 0 {
+				sb.WriteByte('\n')
+			} else {
+				sb.WriteByte(' ')
+			}
+		}
+		if i%100 == 0 {
+			sb.WriteString("needle\n")
+		}
+		files[i] = []byte(sb.String())
+	}
+	return files
+}
+
+// benchmarkPairs are the pairs of the benchmarks, with a single required
+// literal and with many of them.
+var benchmarkPairs = map[string]pairset{
+	"literal": {
+		{pattern: regexp.MustCompile(`needle\s+(\w+)`), replacement: []byte("pin $1")},
+	},
+	"alternation": {
+		{pattern: regexp.MustCompile(`\b(needle|haystack|thread|button)s?\b`), replacement: []byte("pin")},
+		{pattern: regexp.MustCompile(`(?m)^(zip|zap)\d+$`), replacement: []byte("")},
+	},
+}
+
+// BenchmarkPrefilter compares rejecting the files of a synthetic tree with
+// the prefilter and with the regular expressions.
+func BenchmarkPrefilter(b *testing.B) {
+	files := syntheticTree(1000, 8<<10)
+	var size int64
+	for _, f := range files {
+		size += int64(len(f))
+	}
+
+	for name, pairs := range benchmarkPairs {
+		filter := newPrefilter(pairs)
+		if filter == nil {
+			b.Fatalf("%s: expected a prefilter", name)
+		}
+
+		b.Run(name+"/prefilter", func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				for _, f := range files {
+					if filter.match(f) {
+						pairs.replaceAll(f)
+					}
+				}
+			}
+		})
+		b.Run(name+"/regexp", func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				for _, f := range files {
+					pairs.replaceAll(f)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWalk_Prefilter walks a synthetic tree on disk in which only one
+// file in a hundred contains a match.
+func BenchmarkWalk_Prefilter(b *testing.B) {
+	var (
+		files = make(map[string]string)
+		size  int64
+	)
+	for i, f := range syntheticTree(1000, 8<<10) {
+		files[fmt.Sprintf("d%02d/f%04d.txt", i%20, i)] = string(f)
+		size += int64(len(f))
+	}
+	dir := newTree(b, files)
+
+	// The replacement doesn't remove the match, so every iteration finds
+	// and rewrites the same files.
+	pairs := pairset{{pattern: regexp.MustCompile(`needle(\s)`), replacement: []byte("needle$1")}}
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := newRenameWalker(pairs...)
+		w.ReplaceNames = false
+		w.Walk(dir)
+	}
+}
@@ -0,0 +1,560 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	includeRe = regexp.MustCompile(`(?m)^[ \t]*#[ \t]*include[ \t]*"([^"\n]+)"`)
+	importRe  = regexp.MustCompile(`(?:\bfrom|\bimport|\brequire)\s*\(?\s*['"](\.\.?/[^'"\n]*)['"]`)
+
+	// jsExts are the extensions that can be omitted in JS and TS imports.
+	jsExts = []string{".ts", ".tsx", ".d.ts", ".js", ".jsx", ".mjs", ".cjs", ".mts", ".cts"}
+)
+
+// edit is the replacement of the bytes between start and end with text.
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+// applyEdits returns b with the non overlapping edits applied.
+func applyEdits(b []byte, edits []edit) []byte {
+	if len(edits) == 0 {
+		return b
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var (
+		out  []byte
+		last int
+	)
+	for _, e := range edits {
+		out = append(out, b[last:e.start]...)
+		out = append(out, e.text...)
+		last = e.end
+	}
+	return append(out, b[last:]...)
+}
+
+type goModule struct {
+	root string
+	path string
+}
+
+// importPath returns the import path of the package in dir, inside the
+// module.
+func (mod *goModule) importPath(dir string) string {
+	rel, err := filepath.Rel(mod.root, dir)
+	if err != nil || rel == "." {
+		return mod.path
+	}
+	return mod.path + "/" + filepath.ToSlash(rel)
+}
+
+// refMap maps the paths before a set of renames to the paths after them.
+type refMap struct {
+	// renames are the renames with absolute paths in the order they
+	// are performed.
+	renames []rename
+	// dirs maps the directories whose files are all moved to the same
+	// directory to it.
+	dirs    map[string]string
+	modules moduleCache
+	// performed reports whether the renames have been performed, so that
+	// the renamed files are looked up at their new paths.
+	performed bool
+}
+
+func newRefMap(renames []rename) *refMap {
+	m := &refMap{
+		dirs:    make(map[string]string),
+		modules: make(moduleCache),
+	}
+
+	var (
+		sources = make(map[string]string)
+		targets = make(map[string]map[string]bool)
+	)
+	for _, r := range renames {
+		oldpath, err1 := filepath.Abs(r.old)
+		newpath, err2 := filepath.Abs(r.new)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		m.renames = append(m.renames, rename{old: oldpath, new: newpath, root: r.root})
+		sources[oldpath] = newpath
+
+		if olddir, newdir := filepath.Dir(oldpath), filepath.Dir(newpath); olddir != newdir {
+			if targets[olddir] == nil {
+				targets[olddir] = make(map[string]bool)
+			}
+			targets[olddir][newdir] = true
+		}
+	}
+
+	// A directory moves along with its files if all of them are moved to
+	// the same directory.
+	for olddir, newdirs := range targets {
+		if len(newdirs) != 1 {
+			continue
+		}
+		entries, err := os.ReadDir(olddir)
+		if err != nil {
+			continue
+		}
+
+		moved := true
+		for _, e := range entries {
+			if _, ok := sources[filepath.Join(olddir, e.Name())]; !ok && !e.IsDir() {
+				moved = false
+				break
+			}
+		}
+		if moved {
+			for newdir := range newdirs {
+				m.dirs[olddir] = newdir
+			}
+		}
+	}
+	return m
+}
+
+// remove removes the renames in failed from the map, along with the moves of
+// the directories their files were in.
+func (m *refMap) remove(failed []rename) {
+	for _, f := range failed {
+		oldpath, err := filepath.Abs(f.old)
+		if err != nil {
+			continue
+		}
+		delete(m.dirs, filepath.Dir(oldpath))
+		for i, r := range m.renames {
+			if r.old == oldpath {
+				m.renames = append(m.renames[:i], m.renames[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// mapPath returns the absolute path p will have after the renames.
+func (m *refMap) mapPath(p string) string {
+	for _, r := range m.renames {
+		if p == r.old {
+			p = r.new
+		} else if isInside(p, r.old) {
+			p = r.new + p[len(r.old):]
+		}
+	}
+	return p
+}
+
+// current returns the path the file at the absolute path p before the renames
+// is found at.
+func (m *refMap) current(p string) string {
+	if !m.performed {
+		return p
+	}
+	return m.mapPath(p)
+}
+
+// mapDir returns the absolute path the directory dir will have after the renames.
+func (m *refMap) mapDir(dir string) string {
+	if newdir, ok := m.dirs[dir]; ok {
+		return newdir
+	}
+	return m.mapPath(dir)
+}
+
+// relRef returns the path of target relative to the directory of the file
+// from after the renames.
+// It returns false if neither from nor target are affected by the renames.
+func (m *refMap) relRef(from, target string) (string, bool) {
+	newfrom, newtarget := m.mapPath(from), m.mapPath(target)
+	if filepath.Dir(newfrom) == filepath.Dir(from) && newtarget == target {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(newfrom), newtarget)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// rewrite returns the content b of the file at the absolute path p with the
+// references to the renamed paths updated.
+func (m *refMap) rewrite(p string, b []byte) []byte {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".go":
+		return m.rewriteGo(p, b)
+	case ".c", ".h", ".cc", ".cpp", ".cxx", ".hh", ".hpp", ".hxx", ".m", ".mm":
+		return m.rewriteIncludes(p, b)
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".mts", ".cts":
+		return m.rewriteImports(p, b)
+	default:
+		return m.rewriteText(b)
+	}
+}
+
+// rewriteGo updates the import paths of the moved packages in a Go source file.
+func (m *refMap) rewriteGo(p string, b []byte) []byte {
+	mod := m.module(filepath.Dir(p))
+	if mod == nil {
+		return b
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, p, b, parser.ImportsOnly)
+	if err != nil {
+		return b
+	}
+
+	var edits []edit
+	for _, imp := range f.Imports {
+		ipath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || (ipath != mod.path && !strings.HasPrefix(ipath, mod.path+"/")) {
+			continue
+		}
+
+		dir := filepath.Join(mod.root, filepath.FromSlash(strings.TrimPrefix(ipath, mod.path)))
+		newdir := m.mapDir(dir)
+		if newdir == dir {
+			continue
+		}
+		rel, err := filepath.Rel(mod.root, newdir)
+		if err != nil || !filepath.IsLocal(rel) && rel != "." {
+			continue
+		}
+
+		newipath := mod.path
+		if rel != "." {
+			newipath += "/" + filepath.ToSlash(rel)
+		}
+		edits = append(edits, edit{
+			start: fset.Position(imp.Path.Pos()).Offset,
+			end:   fset.Position(imp.Path.End()).Offset,
+			text:  strconv.Quote(newipath),
+		})
+	}
+	return applyEdits(b, edits)
+}
+
+// module returns the Go module containing the directory dir, with the path
+// its root had before the renames.
+func (m *refMap) module(dir string) *goModule {
+	mod := m.modules.lookup(m.current(dir))
+	if mod == nil || !m.performed {
+		return mod
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		if m.mapPath(d) == mod.root {
+			return &goModule{root: d, path: mod.path}
+		}
+		if d == filepath.Dir(d) {
+			return nil
+		}
+	}
+}
+
+// moduleCache caches the Go modules containing the looked up directories.
+type moduleCache map[string]*goModule
+
+// lookup returns the Go module containing dir, or nil if there is none.
+func (c moduleCache) lookup(dir string) *goModule {
+	mod, ok := c[dir]
+	if ok {
+		return mod
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		if modpath := modulePath(b); modpath != "" {
+			mod = &goModule{root: dir, path: modpath}
+		}
+	} else if parent := filepath.Dir(dir); parent != dir {
+		mod = c.lookup(parent)
+	}
+	c[dir] = mod
+	return mod
+}
+
+// modulePath returns the module path declared in the content of a go.mod file.
+func modulePath(gomod []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(gomod))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			if p, err := strconv.Unquote(fields[1]); err == nil {
+				return p
+			}
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// rewriteIncludes updates the C and C++ local includes of the renamed files.
+func (m *refMap) rewriteIncludes(p string, b []byte) []byte {
+	var edits []edit
+
+	for _, match := range includeRe.FindAllSubmatchIndex(b, -1) {
+		spec := string(b[match[2]:match[3]])
+
+		target := filepath.Join(filepath.Dir(p), filepath.FromSlash(spec))
+		if !exists(m.current(target)) {
+			continue
+		}
+		if rel, ok := m.relRef(p, target); ok && rel != path.Clean(spec) {
+			edits = append(edits, edit{start: match[2], end: match[3], text: rel})
+		}
+	}
+	return applyEdits(b, edits)
+}
+
+// rewriteImports updates the JavaScript and TypeScript relative imports of
+// the renamed files, keeping the extensions and the index files implicit.
+func (m *refMap) rewriteImports(p string, b []byte) []byte {
+	var edits []edit
+
+	for _, match := range importRe.FindAllSubmatchIndex(b, -1) {
+		spec := string(b[match[2]:match[3]])
+
+		base := filepath.Join(filepath.Dir(p), filepath.FromSlash(spec))
+		target, ext, index := m.resolveImport(base)
+		if target == "" {
+			continue
+		}
+
+		var (
+			newfrom   = m.mapPath(p)
+			newtarget = m.mapPath(target)
+		)
+		// Index files are imported through their directory.
+		if index {
+			target, newtarget = filepath.Dir(target), filepath.Dir(newtarget)
+		}
+		if filepath.Dir(newfrom) == filepath.Dir(p) && newtarget == target {
+			continue
+		}
+
+		r, err := filepath.Rel(filepath.Dir(newfrom), newtarget)
+		if err != nil {
+			continue
+		}
+		rel := filepath.ToSlash(r)
+
+		rel = strings.TrimSuffix(rel, ext)
+		if !strings.HasPrefix(rel, "../") {
+			rel = "./" + rel
+		}
+		if rel != spec && rel != "./"+path.Clean(spec) {
+			edits = append(edits, edit{start: match[2], end: match[3], text: rel})
+		}
+	}
+	return applyEdits(b, edits)
+}
+
+// resolveImport returns the file imported by a relative JS or TS import
+// resolved to base, the extension omitted in the import and whether the
+// file is the index of the imported directory.
+func (m *refMap) resolveImport(base string) (target, ext string, index bool) {
+	if isFile(m.current(base)) {
+		return base, "", false
+	}
+	for _, ext := range jsExts {
+		if isFile(m.current(base + ext)) {
+			return base + ext, ext, false
+		}
+	}
+	for _, ext := range jsExts {
+		if f := filepath.Join(base, "index"+ext); isFile(m.current(f)) {
+			return f, "", true
+		}
+	}
+	return "", "", false
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// rewriteText replaces in b the paths relative to the walked directories and
+// the base names of the renamed files.
+func (m *refMap) rewriteText(b []byte) []byte {
+	// Leave binary files alone.
+	head := b
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return b
+	}
+
+	var names [][2]string
+	for _, r := range m.renames {
+		if r.root == "" {
+			continue
+		}
+		root, err := filepath.Abs(r.root)
+		if err != nil {
+			continue
+		}
+
+		oldrel, err1 := filepath.Rel(root, r.old)
+		newrel, err2 := filepath.Rel(root, r.new)
+		if err1 == nil && err2 == nil && filepath.IsLocal(oldrel) {
+			names = append(names, [2]string{filepath.ToSlash(oldrel), filepath.ToSlash(newrel)})
+		}
+		// Only the base names with an extension are specific enough.
+		if oldbase, newbase := filepath.Base(r.old), filepath.Base(r.new); oldbase != newbase && strings.Contains(oldbase, ".") {
+			names = append(names, [2]string{oldbase, newbase})
+		}
+	}
+	return replaceNames(b, names)
+}
+
+// replaceNames replaces in b, in a single pass, the whole occurrences of
+// each name with its replacement, preferring the longest names.
+func replaceNames(b []byte, names [][2]string) []byte {
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i][0]) > len(names[j][0]) })
+
+	var (
+		out  []byte
+		last int
+	)
+	for i := 0; i < len(b); i++ {
+		// Names can only start at the beginning of a word.
+		if i > 0 && (isWordByte(b[i-1]) || b[i-1] == '.' || b[i-1] == '-') {
+			continue
+		}
+		for _, n := range names {
+			end := i + len(n[0])
+			if !bytes.HasPrefix(b[i:], []byte(n[0])) || continuesName(b, end) {
+				continue
+			}
+			out = append(out, b[last:i]...)
+			out = append(out, n[1]...)
+			last = end
+			i = end - 1
+			break
+		}
+	}
+	if out == nil {
+		return b
+	}
+	return append(out, b[last:]...)
+}
+
+// continuesName reports whether the name ending at i in b goes on, like in
+// "name.ext" when looking for "name".
+func continuesName(b []byte, i int) bool {
+	if i >= len(b) {
+		return false
+	}
+	if isWordByte(b[i]) || b[i] == '-' {
+		return true
+	}
+	return b[i] == '.' && i+1 < len(b) && isWordByte(b[i+1])
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// updateRefs rewrites the references to the renamed paths in m in the walked
+// files, once the renames in done have been performed.
+func (w *walker) updateRefs(m *refMap, done []rename) {
+	if w.ToStdout || len(m.renames) == 0 {
+		return
+	}
+	// The original files are left untouched when writing to OutDir.
+	m.performed = w.OutDir == ""
+	moved := make(map[string]string, len(done))
+	for _, r := range done {
+		moved[r.old] = r.new
+	}
+
+	for _, p := range w.refFiles {
+		// The walked files might have been renamed as well, only in
+		// OutDir if it is set.
+		cur := w.renamedPath(moved, p)
+		src := cur
+		if w.OutDir != "" {
+			src = p
+			// Update the files already written to OutDir in place.
+			if w.mirror.has(p) {
+				src = w.outPath(cur)
+			}
+		}
+		info, err := os.Stat(src)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		b, err := os.ReadFile(src)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+
+		updated := m.rewrite(abs, b)
+		if bytes.Equal(updated, b) {
+			continue
+		}
+		dst := cur
+		if w.OutDir != "" {
+			if dst, err = w.outFile(cur); err != nil {
+				w.fail(err)
+				continue
+			}
+		}
+		if w.IsVerbose {
+			fmt.Fprintf(w.stdout(), "updating references in %s\n", dst)
+		}
+		if err := w.writeFile(dst, info, updated); err != nil {
+			w.fail(err)
+			continue
+		}
+		w.rec.written(p)
+		if w.OutDir != "" {
+			w.mirror.add(p)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package jet
+
+import (
+	"regexp"
+	"testing"
+)
+
+// TestWalkerUpdateRefs tests that the references to the renamed files are
+// updated in each supported language.
+func TestWalkerUpdateRefs(t *testing.T) {
+	root := newTree(t, map[string]string{
+		"go.mod":               "module example.com/m\n\ngo 1.20\n",
+		"main.go":              "package main\n\nimport (\n\t\"fmt\"\n\t\"example.com/m/oldpkg\"\n\t\"example.com/m/oldpkg/sub\"\n)\n",
+		"oldpkg/a.go":          "package oldpkg\n",
+		"oldpkg/sub/b.go":      "package sub\n",
+		"csrc/oldpkg.h":        "int f(void);\n",
+		"csrc/main.c":          "#include <stdio.h>\n#include \"oldpkg.h\"\n",
+		"other/x.c":            "# include \"../csrc/oldpkg.h\"\n",
+		"web/oldpkg/index.ts":  "export const a = 1;\n",
+		"web/oldpkg_util.ts":   "export const b = 2;\n",
+		"web/app.ts":           "import { a } from './oldpkg';\nimport { b } from \"./oldpkg_util\";\nconst c = require('./missing');\n",
+		"Makefile":             "build: csrc/oldpkg.h\n\tcc csrc/main.c # oldpkg.h\n",
+		"docs/notes.txt":       "see csrc/oldpkg.hpp and myoldpkg.h\n",
+		"web/oldpkg/README.md": "nothing here\n",
+	})
+
+	w := newRenameWalker(pair{pattern: regexp.MustCompile("oldpkg"), replacement: []byte("newpkg")})
+	w.NamesOnly = true
+	w.UpdateRefs = true
+
+	output := captureStdout(func() {
+		w.Walk(root)
+	})
+	if output != "" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	checkTree(t, root, map[string]string{
+		"main.go":             "package main\n\nimport (\n\t\"fmt\"\n\t\"example.com/m/newpkg\"\n\t\"example.com/m/newpkg/sub\"\n)\n",
+		"newpkg/a.go":         "package oldpkg\n",
+		"csrc/main.c":         "#include <stdio.h>\n#include \"newpkg.h\"\n",
+		"other/x.c":           "# include \"../csrc/newpkg.h\"\n",
+		"web/app.ts":          "import { a } from './newpkg';\nimport { b } from \"./newpkg_util\";\nconst c = require('./missing');\n",
+		"Makefile":            "build: csrc/newpkg.h\n\tcc csrc/main.c # newpkg.h\n",
+		"docs/notes.txt":      "see csrc/oldpkg.hpp and myoldpkg.h\n",
+		"web/newpkg/index.ts": "export const a = 1;\n",
+	})
+}
+
+// TestWalkerUpdateRefs_ReplacePaths tests that the relative references of
+// the moved files are updated as well.
+func TestWalkerUpdateRefs_ReplacePaths(t *testing.T) {
+	root := newTree(t, map[string]string{
+		"src/a.js":    "import b from './b.js';\n",
+		"src/b.js":    "export default 1;\n",
+		"src/main.js": "import a from './a';\n",
+	})
+
+	w := newRenameWalker(pair{pattern: regexp.MustCompile(`^src/a\.js$`), replacement: []byte("lib/a.js")})
+	w.ReplaceNames = false
+	w.ReplacePaths = true
+	w.NamesOnly = true
+	w.UpdateRefs = true
+	w.Walk(root)
+
+	checkTree(t, root, map[string]string{
+		"lib/a.js":    "import b from '../src/b.js';\n",
+		"src/main.js": "import a from '../lib/a';\n",
+	})
+}
+
+// TestWalkerUpdateRefs_RenameError tests that the references are only
+// updated for the renames that have been performed.
+func TestWalkerUpdateRefs_RenameError(t *testing.T) {
+	root := newTree(t, map[string]string{
+		"lib":         "not a directory\n",
+		"src/a.js":    "export default 1;\n",
+		"src/b.js":    "export default 2;\n",
+		"src/main.js": "import a from './a';\nimport b from './b';\n",
+	})
+
+	w := newRenameWalker(
+		pair{pattern: regexp.MustCompile(`^src/a\.js$`), replacement: []byte("lib/a.js")},
+		pair{pattern: regexp.MustCompile(`^src/b\.js$`), replacement: []byte("src/c.js")},
+	)
+	w.ReplaceNames = false
+	w.ReplacePaths = true
+	w.NamesOnly = true
+	w.UpdateRefs = true
+	captureStdout(func() {
+		w.Walk(root)
+	})
+
+	checkTree(t, root, map[string]string{
+		"src/a.js":    "export default 1;\n",
+		"src/c.js":    "export default 2;\n",
+		"src/main.js": "import a from './a';\nimport b from './c';\n",
+	})
+}
+
+// TestReplaceNames tests the replaceNames function.
+func TestReplaceNames(t *testing.T) {
+	names := [][2]string{{"a.go", "b.go"}, {"b.go", "c.go"}, {"dir/a.go", "x/y.go"}}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a.go", "b.go"},
+		{"a.go b.go", "b.go c.go"},
+		{"see dir/a.go", "see x/y.go"},
+		{"src/a.go", "src/b.go"},
+		{"xa.go a.gox .a.go a.go.", "xa.go a.gox .a.go b.go."},
+	}
+
+	for _, test := range tests {
+		if result := string(replaceNames([]byte(test.input), names)); result != test.expected {
+			t.Errorf("replaceNames(%q) = %q; want %q", test.input, result, test.expected)
+		}
+	}
+}
+
+// TestModulePath tests the modulePath function.
+func TestModulePath(t *testing.T) {
+	tests := []struct {
+		gomod    string
+		expected string
+	}{
+		{"module example.com/m\n", "example.com/m"},
+		{"// comment\nmodule \"example.com/q\"\n\ngo 1.20\n", "example.com/q"},
+		{"go 1.20\n", ""},
+	}
+
+	for _, test := range tests {
+		if result := modulePath([]byte(test.gomod)); result != test.expected {
+			t.Errorf("modulePath(%q) = %q; want %q", test.gomod, result, test.expected)
+		}
+	}
+}
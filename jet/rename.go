@@ -0,0 +1,305 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type rename struct {
+	old string
+	new string
+	// root is the walked directory, if any.
+	root string
+}
+
+// renaming reports whether the walker renames any file.
+func (w *walker) renaming() bool {
+	return w.ReplaceNames || w.NamesOnly || w.ReplacePaths
+}
+
+// walkRoot returns the directory path is relative to when walking root.
+func walkRoot(root string) string {
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		return filepath.Dir(root)
+	}
+	return root
+}
+
+// planRename records the rename of path, if its name changes, to be
+// performed once the walk is over, and reports whether it is renamed.
+func (w *walker) planRename(path string) bool {
+	if !w.ReplacePaths {
+		if newpath := w.newPath(path); newpath != path {
+			w.renames = append(w.renames, rename{old: path, new: newpath, root: w.root})
+			return true
+		}
+		return false
+	}
+
+	newpath, err := w.movedPath(w.root, path)
+	if err != nil {
+		w.fail(err)
+		return false
+	}
+	if newpath != path {
+		w.renames = append(w.renames, rename{old: path, new: newpath, root: w.root})
+		return true
+	}
+	return false
+}
+
+// applyRenames performs all the planned renames, already checked by
+// startJobs, starting from the deepest paths so that no path changes before
+// its children are renamed.
+func (w *walker) applyRenames() {
+	renames := w.renames
+	w.renames = nil
+
+	renames = orderRenames(renames)
+	// The directories moved along with their files are found before
+	// anything is renamed.
+	var refs *refMap
+	if w.UpdateRefs {
+		refs = newRefMap(renames)
+	}
+
+	var failed []rename
+	// The original files are left untouched when writing to OutDir.
+	if w.OutDir != "" {
+		failed = w.moveMirrored(renames)
+	} else {
+		for _, r := range renames {
+			if err := w.rename(r.old, r.new); err != nil {
+				w.fail(err)
+				failed = append(failed, r)
+				continue
+			}
+			w.rec.renamed(r.old, r.new)
+			if r.root != "" && filepath.Dir(r.old) != filepath.Dir(r.new) {
+				removeEmptyDirs(filepath.Dir(r.old), r.root)
+			}
+		}
+	}
+
+	if w.UpdateRefs {
+		refs.remove(failed)
+		w.updateRefs(refs, performed(renames, failed))
+	}
+}
+
+// performed returns the renames that are not in failed.
+func performed(renames, failed []rename) []rename {
+	if len(failed) == 0 {
+		return renames
+	}
+	var done []rename
+	for _, r := range renames {
+		ok := true
+		for _, f := range failed {
+			if r.old == f.old {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			done = append(done, r)
+		}
+	}
+	return done
+}
+
+// checkRenames returns a description of each conflict among renames, that is
+// multiple paths renamed to the same one, paths renamed to existing files
+// and cycles of renames.
+func checkRenames(renames []rename) (conflicts []string) {
+	var (
+		targets = make(map[string]string)
+		sources = make(map[string]string)
+	)
+	for _, r := range renames {
+		sources[r.old] = r.new
+	}
+
+	for _, r := range renames {
+		if other, ok := targets[r.new]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("cannot rename both %s and %s to %s", other, r.old, r.new))
+			continue
+		}
+		targets[r.new] = r.old
+
+		// The target can exist only if it is renamed in turn or if it's the
+		// same file, like on case insensitive file systems.
+		if _, renamed := sources[r.new]; !renamed && exists(r.new) && !sameFile(r.old, r.new) {
+			conflicts = append(conflicts, fmt.Sprintf("cannot rename %s to %s: file exists", r.old, r.new))
+		}
+	}
+
+	for _, r := range renames {
+		// Follow the chain of renames starting from r to detect cycles.
+		next, ok := r.new, true
+		for i := 0; ok && i < len(renames); i++ {
+			if next == r.old {
+				conflicts = append(conflicts, fmt.Sprintf("cannot rename %s to %s: cycle of renames", r.old, r.new))
+				break
+			}
+			next, ok = sources[next]
+		}
+	}
+	return
+}
+
+// orderRenames returns the renames sorted from the deepest path to the
+// shallowest, where each path is renamed before any other path is renamed
+// to it.
+func orderRenames(renames []rename) []rename {
+	sorted := make([]rename, len(renames))
+	copy(sorted, renames)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		di, dj := depth(sorted[i].old), depth(sorted[j].old)
+		if di != dj {
+			return di > dj
+		}
+		return sorted[i].old < sorted[j].old
+	})
+
+	pending := make(map[string]bool)
+	for _, r := range sorted {
+		pending[r.old] = true
+	}
+
+	ordered := make([]rename, 0, len(sorted))
+	for len(sorted) > 0 {
+		// The renames of paths with the same depth.
+		n := 1
+		for n < len(sorted) && depth(sorted[n].old) == depth(sorted[0].old) {
+			n++
+		}
+		level := sorted[:n]
+		sorted = sorted[n:]
+
+		// Defer the renames to paths that still have to be renamed themselves.
+		for len(level) > 0 {
+			var deferred []rename
+			for _, r := range level {
+				if pending[r.new] {
+					deferred = append(deferred, r)
+					continue
+				}
+				ordered = append(ordered, r)
+				delete(pending, r.old)
+			}
+			// Only cycles are left, which are reported by checkRenames.
+			if len(deferred) == len(level) {
+				ordered = append(ordered, deferred...)
+				break
+			}
+			level = deferred
+		}
+	}
+	return ordered
+}
+
+// rename renames oldpath to newpath, with git mv in git mode.
+// The missing parent directories of newpath are created.
+func (w *walker) rename(oldpath, newpath string) error {
+	if w.IsVerbose {
+		fmt.Fprintf(w.stdout(), "renaming %s to %s\n", oldpath, newpath)
+	}
+	if dir := filepath.Dir(newpath); dir != filepath.Dir(oldpath) {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+	}
+	if w.Git {
+		return gitMove(oldpath, newpath)
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+// newPath returns path with the pairs applied to its base name.
+func (w *walker) newPath(path string) string {
+	var (
+		base    = filepath.Base(path)
+		newbase = string(w.pairs.replaceAll([]byte(base)))
+	)
+
+	if newbase == base {
+		return path
+	}
+	return filepath.Join(filepath.Dir(path), newbase)
+}
+
+// movedPath returns path with the pairs applied to its path relative to root.
+// If root is empty the pairs are applied to the whole path.
+func (w *walker) movedPath(root, path string) (string, error) {
+	rel := path
+	if root != "" {
+		var err error
+		if rel, err = filepath.Rel(root, path); err != nil {
+			return "", err
+		}
+	}
+
+	newrel := filepath.Clean(filepath.FromSlash(
+		string(w.pairs.replaceAll([]byte(filepath.ToSlash(rel)))),
+	))
+	if newrel == rel {
+		return path, nil
+	}
+	if root != "" && !filepath.IsLocal(newrel) {
+		return "", fmt.Errorf("cannot move %s to %s: outside of %s", path, newrel, root)
+	}
+	return filepath.Join(root, newrel), nil
+}
+
+// removeEmptyDirs removes dir and its parents up to root, excluded, as long
+// as they are empty.
+func removeEmptyDirs(dir, root string) {
+	for {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || !filepath.IsLocal(rel) {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func sameFile(a, b string) bool {
+	ia, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Lstat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ia, ib)
+}
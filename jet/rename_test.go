@@ -0,0 +1,181 @@
+package jet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newRenameWalker(pairs ...pair) *walker {
+	return &walker{
+		Options: Options{
+			Glob:         "*",
+			MaxDepth:     -1,
+			ReplaceNames: true,
+		},
+		WaitGroup: new(sync.WaitGroup),
+		pairs:     pairs,
+	}
+}
+
+// fooFiles returns the files at the given paths, all containing "foo".
+func fooFiles(names ...string) map[string]string {
+	files := make(map[string]string, len(names))
+	for _, name := range names {
+		files[name] = "foo"
+	}
+	return files
+}
+
+// TestWalkerWalk_RenameDirectories tests that directories and their children
+// are renamed and edited together.
+func TestWalkerWalk_RenameDirectories(t *testing.T) {
+	root := newTree(t, fooFiles("foo/foo.txt", "foo/foo/foo.txt", "foo/bar.txt"))
+
+	w := newRenameWalker(pair{pattern: regexp.MustCompile("foo"), replacement: []byte("baz")})
+	output := captureStdout(func() {
+		w.Walk(filepath.Join(root, "foo"))
+	})
+	if output != "" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	for _, f := range []string{"baz/baz.txt", "baz/baz/baz.txt", "baz/bar.txt"} {
+		content, err := os.ReadFile(filepath.Join(root, f))
+		if err != nil {
+			t.Errorf("expected %s to exist: %v", f, err)
+			continue
+		}
+		if string(content) != "baz" {
+			t.Errorf("expected %s to contain 'baz', got %q", f, content)
+		}
+	}
+}
+
+// TestWalkerWalk_RenameCollision tests that nothing is edited or renamed
+// when multiple files would be renamed to the same path.
+func TestWalkerWalk_RenameCollision(t *testing.T) {
+	for _, namesOnly := range []bool{false, true} {
+		files := fooFiles("a1.txt", "a2.txt", "b.txt")
+		root := newTree(t, files)
+
+		w := newRenameWalker(
+			pair{pattern: regexp.MustCompile(`a\d`), replacement: []byte("a")},
+			pair{pattern: regexp.MustCompile(`^b`), replacement: []byte("c")},
+			pair{pattern: regexp.MustCompile(`foo`), replacement: []byte("bar")},
+		)
+		w.NamesOnly = namesOnly
+
+		output := captureStdout(func() {
+			w.Walk(root)
+		})
+		if !strings.Contains(output, "a.txt") || !w.conflicted {
+			t.Errorf("namesOnly %v: expected a conflict report, got %q", namesOnly, output)
+		}
+		checkTree(t, root, files)
+	}
+}
+
+// TestCheckRenames tests the checkRenames function.
+func TestCheckRenames(t *testing.T) {
+	root := newTree(t, fooFiles("a", "b", "c", "existing"))
+	p := func(name string) string { return filepath.Join(root, name) }
+
+	tests := []struct {
+		name      string
+		renames   []rename
+		conflicts int
+	}{
+		{"no conflicts", []rename{{old: p("a"), new: p("x")}, {old: p("b"), new: p("y")}}, 0},
+		{"same target", []rename{{old: p("a"), new: p("x")}, {old: p("b"), new: p("x")}}, 1},
+		{"existing target", []rename{{old: p("a"), new: p("existing")}}, 1},
+		{"chain", []rename{{old: p("a"), new: p("b")}, {old: p("b"), new: p("c")}, {old: p("c"), new: p("d")}}, 0},
+		{"cycle", []rename{{old: p("a"), new: p("b")}, {old: p("b"), new: p("a")}}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conflicts := checkRenames(test.renames)
+			if len(conflicts) != test.conflicts {
+				t.Errorf("expected %d conflicts, got %v", test.conflicts, conflicts)
+			}
+		})
+	}
+}
+
+// TestOrderRenames tests that the renames are sorted deepest first and that
+// the paths are freed before being used as targets.
+func TestOrderRenames(t *testing.T) {
+	renames := []rename{
+		{old: "a", new: "b"},
+		{old: "d/x", new: "d/y"},
+		{old: "b", new: "c"},
+		{old: "d", new: "e"},
+		{old: "d/y", new: "d/z"},
+	}
+	expected := []rename{
+		{old: "d/y", new: "d/z"},
+		{old: "d/x", new: "d/y"},
+		{old: "b", new: "c"},
+		{old: "d", new: "e"},
+		{old: "a", new: "b"},
+	}
+
+	if ordered := orderRenames(renames); !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("orderRenames() = %v; want %v", ordered, expected)
+	}
+}
+
+// TestWalkerWalk_ReplacePaths tests that the files are moved to the replaced
+// relative paths and that the emptied directories are removed.
+func TestWalkerWalk_ReplacePaths(t *testing.T) {
+	root := newTree(t, fooFiles("pkg/foo/bar.go", "pkg/foo/sub/baz.go", "pkg/other/x.go"))
+
+	w := newRenameWalker(pair{pattern: regexp.MustCompile(`^pkg/foo/`), replacement: []byte("internal/foo/")})
+	w.ReplaceNames = false
+	w.ReplacePaths = true
+	w.NamesOnly = true
+
+	output := captureStdout(func() {
+		w.Walk(root)
+	})
+	if output != "" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	for _, f := range []string{"internal/foo/bar.go", "internal/foo/sub/baz.go", "pkg/other/x.go"} {
+		content, err := os.ReadFile(filepath.Join(root, f))
+		if err != nil {
+			t.Errorf("expected %s to exist: %v", f, err)
+		} else if string(content) != "foo" {
+			t.Errorf("expected %s not to be edited, got %q", f, content)
+		}
+	}
+	if exists(filepath.Join(root, "pkg", "foo")) {
+		t.Errorf("expected the emptied directory pkg/foo to be removed")
+	}
+	if !exists(filepath.Join(root, "pkg")) {
+		t.Errorf("expected pkg to be kept since it's not empty")
+	}
+}
+
+// TestWalkerMovedPath tests the movedPath method of the walker struct.
+func TestWalkerMovedPath(t *testing.T) {
+	w := newRenameWalker(pair{pattern: regexp.MustCompile(`^a/`), replacement: []byte("../")})
+
+	if _, err := w.movedPath("root", filepath.Join("root", "a", "b.txt")); err == nil {
+		t.Errorf("expected an error when moving a file outside of the root")
+	}
+
+	newpath, err := w.movedPath("root", filepath.Join("root", "c", "a", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join("root", "c", "a", "b.txt"); newpath != expected {
+		t.Errorf("expected %q, got %q", expected, newpath)
+	}
+}
@@ -0,0 +1,335 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	codeToken tokenKind = iota
+	commentToken
+	stringToken
+)
+
+// span is a region of a file containing code or the body of a comment or of
+// a string literal, without its delimiters.
+type span struct {
+	start int
+	end   int
+	kind  tokenKind
+}
+
+// quote is a string literal delimiter.
+type quote struct {
+	delim string
+	// raw strings have no escape sequences.
+	raw bool
+	// multiline strings can span more lines.
+	multiline bool
+}
+
+// syntax describes the comments and the string literals of a language.
+type syntax struct {
+	line   []string
+	block  [][2]string
+	quotes []quote
+}
+
+var (
+	cSyntax = &syntax{
+		line:   []string{"//"},
+		block:  [][2]string{{"/*", "*/"}},
+		quotes: []quote{{`"`, false, false}, {`'`, false, false}},
+	}
+	goSyntax = &syntax{
+		line:   []string{"//"},
+		block:  [][2]string{{"/*", "*/"}},
+		quotes: []quote{{"`", true, true}, {`"`, false, false}, {`'`, false, false}},
+	}
+	jsSyntax = &syntax{
+		line:   []string{"//"},
+		block:  [][2]string{{"/*", "*/"}},
+		quotes: []quote{{"`", false, true}, {`"`, false, false}, {`'`, false, false}},
+	}
+	// Single quotes are not strings in Rust, where they also mark lifetimes.
+	rustSyntax = &syntax{
+		line:   []string{"//"},
+		block:  [][2]string{{"/*", "*/"}},
+		quotes: []quote{{`"`, false, true}},
+	}
+	pySyntax = &syntax{
+		line:   []string{"#"},
+		quotes: []quote{{`"""`, false, true}, {`'''`, false, true}, {`"`, false, false}, {`'`, false, false}},
+	}
+	shSyntax = &syntax{
+		line:   []string{"#"},
+		quotes: []quote{{`"`, false, true}, {`'`, true, true}},
+	}
+	hashSyntax = &syntax{
+		line:   []string{"#"},
+		quotes: []quote{{`"`, false, false}, {`'`, false, false}},
+	}
+	tomlSyntax = &syntax{
+		line:   []string{"#"},
+		quotes: []quote{{`"""`, false, true}, {`'''`, true, true}, {`"`, false, false}, {`'`, true, false}},
+	}
+	sqlSyntax = &syntax{
+		line:   []string{"--"},
+		block:  [][2]string{{"/*", "*/"}},
+		quotes: []quote{{`'`, false, true}},
+	}
+	luaSyntax = &syntax{
+		line:   []string{"--"},
+		block:  [][2]string{{"--[[", "]]"}},
+		quotes: []quote{{`"`, false, false}, {`'`, false, false}},
+	}
+	hsSyntax = &syntax{
+		line:   []string{"--"},
+		block:  [][2]string{{"{-", "-}"}},
+		quotes: []quote{{`"`, false, false}},
+	}
+	cssSyntax = &syntax{
+		block:  [][2]string{{"/*", "*/"}},
+		quotes: []quote{{`"`, false, false}, {`'`, false, false}},
+	}
+	htmlSyntax = &syntax{
+		block: [][2]string{{"<!--", "-->"}},
+	}
+
+	// syntaxes are the syntaxes of the languages by file extension.
+	syntaxes = map[string]*syntax{
+		".c": cSyntax, ".h": cSyntax, ".cc": cSyntax, ".cpp": cSyntax, ".cxx": cSyntax,
+		".hh": cSyntax, ".hpp": cSyntax, ".java": cSyntax, ".cs": cSyntax,
+		".kt": cSyntax, ".scala": cSyntax, ".swift": cSyntax, ".dart": cSyntax,
+		".m": cSyntax, ".php": cSyntax, ".proto": cSyntax, ".zig": cSyntax,
+		".go": goSyntax,
+		".js": jsSyntax, ".jsx": jsSyntax, ".mjs": jsSyntax, ".cjs": jsSyntax,
+		".ts": jsSyntax, ".tsx": jsSyntax, ".mts": jsSyntax, ".cts": jsSyntax,
+		".rs": rustSyntax,
+		".py": pySyntax,
+		".sh": shSyntax, ".bash": shSyntax, ".zsh": shSyntax,
+		".rb": hashSyntax, ".pl": hashSyntax, ".r": hashSyntax, ".yaml": hashSyntax,
+		".yml": hashSyntax, ".conf": hashSyntax, ".cfg": hashSyntax, ".mk": hashSyntax,
+		".toml": tomlSyntax,
+		".sql":  sqlSyntax,
+		".lua":  luaSyntax,
+		".hs":   hsSyntax,
+		".css":  cssSyntax, ".scss": cssSyntax,
+		".html": htmlSyntax, ".htm": htmlSyntax, ".xml": htmlSyntax, ".svg": htmlSyntax,
+	}
+
+	// namedSyntaxes are the syntaxes of the files without an extension.
+	namedSyntaxes = map[string]*syntax{
+		"Makefile":    hashSyntax,
+		"makefile":    hashSyntax,
+		"GNUmakefile": hashSyntax,
+		"Dockerfile":  hashSyntax,
+	}
+)
+
+// syntaxFor returns the syntax of the file at path or nil if its language
+// is not known.
+func syntaxFor(path string) *syntax {
+	if s, ok := syntaxes[strings.ToLower(filepath.Ext(path))]; ok {
+		return s
+	}
+	return namedSyntaxes[filepath.Base(path)]
+}
+
+// tokenize splits b in code, comment and string spans according to s.
+// The files of unknown languages are made only of code.
+func tokenize(s *syntax, b []byte) (spans []span) {
+	if s == nil {
+		return []span{{0, len(b), codeToken}}
+	}
+
+	// The code goes from the end of the previous token to the delimiter of
+	// the current one at i.
+	var i, code int
+	add := func(start, end int, kind tokenKind) {
+		if code < i {
+			spans = append(spans, span{code, i, codeToken})
+		}
+		spans = append(spans, span{start, end, kind})
+	}
+
+	for i < len(b) {
+		if next, ok := s.comment(b, i, add); ok {
+			i, code = next, next
+		} else if next, ok := s.string(b, i, add); ok {
+			i, code = next, next
+		} else {
+			i++
+		}
+	}
+	if code < len(b) {
+		spans = append(spans, span{code, len(b), codeToken})
+	}
+	return
+}
+
+// comment adds the body of the comment starting at i in b, if any, and
+// returns the position after its end.
+func (s *syntax) comment(b []byte, i int, add func(int, int, tokenKind)) (int, bool) {
+	for _, bc := range s.block {
+		if !bytes.HasPrefix(b[i:], []byte(bc[0])) {
+			continue
+		}
+		start := i + len(bc[0])
+		end := bytes.Index(b[start:], []byte(bc[1]))
+		if end < 0 {
+			add(start, len(b), commentToken)
+			return len(b), true
+		}
+		add(start, start+end, commentToken)
+		return start + end + len(bc[1]), true
+	}
+
+	for _, lc := range s.line {
+		if !bytes.HasPrefix(b[i:], []byte(lc)) {
+			continue
+		}
+		start := i + len(lc)
+		end := bytes.IndexByte(b[start:], '\n')
+		if end < 0 {
+			end = len(b) - start
+		}
+		add(start, start+end, commentToken)
+		return start + end, true
+	}
+	return 0, false
+}
+
+// string adds the body of the string literal starting at i in b, if any,
+// and returns the position after its end.
+// The quotes of the strings never closed, or not closed on the same line for
+// the single line ones, are considered code, e.g. apostrophes in prose.
+func (s *syntax) string(b []byte, i int, add func(int, int, tokenKind)) (int, bool) {
+	for _, q := range s.quotes {
+		if !bytes.HasPrefix(b[i:], []byte(q.delim)) {
+			continue
+		}
+
+		start := i + len(q.delim)
+		for j := start; j < len(b); j++ {
+			switch {
+			case b[j] == '\\' && !q.raw:
+				j++
+			case b[j] == '\n' && !q.multiline:
+				return 0, false
+			case bytes.HasPrefix(b[j:], []byte(q.delim)):
+				add(start, j, stringToken)
+				return j + len(q.delim), true
+			}
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// scoped reports whether the replacements are restricted to some kinds of
+// tokens.
+func (w *walker) scoped() bool {
+	return w.OnlyComments || w.OnlyStrings || w.OnlyCode
+}
+
+// selects reports whether the replacements are applied to the tokens of
+// the given kind.
+func (w *walker) selects(kind tokenKind) bool {
+	switch kind {
+	case commentToken:
+		return w.OnlyComments
+	case stringToken:
+		return w.OnlyStrings
+	default:
+		return w.OnlyCode
+	}
+}
+
+// streamable reports whether the files can be edited in chunks, that is if
+// the replacements don't depend on the structure of the whole file.
+func (w *walker) streamable() bool {
+	return !w.scoped() && w.KeyPath == "" && len(w.CSVColumns) == 0 && w.Normalize == ""
+}
+
+// replaces reports whether the pairs are applied to the file at path, since
+// the key paths and the columns can only be found in the files of the
+// matching formats.
+func (w *walker) replaces(path string) bool {
+	switch {
+	case len(w.pairs) == 0:
+		return false
+	case w.KeyPath != "":
+		return formatFor(path) != nil
+	case len(w.CSVColumns) > 0:
+		return isTable(path)
+	}
+	return true
+}
+
+// rewrites reports whether the file at path is edited, to apply the pairs or
+// to convert its line endings or its normalization form.
+func (w *walker) rewrites(path string) bool {
+	return w.replaces(path) || w.convertsEOL() || w.NormalizeOutput
+}
+
+// patternless reports whether an operation that doesn't need a pattern and a
+// replacement is requested.
+func (w *walker) patternless() bool {
+	return w.goIdent.isSet() || w.convertsEOL() || w.NormalizeOutput
+}
+
+// replace returns the content b of the file at path with all the pairs
+// applied, only to the selected nodes or tokens if the replacements are
+// scoped.
+func (w *walker) replace(path string, b []byte) []byte {
+	if w.KeyPath != "" {
+		return w.replaceKeys(path, b)
+	}
+	if len(w.CSVColumns) > 0 {
+		return w.replaceColumns(path, b)
+	}
+	if !w.scoped() {
+		return w.pairs.replaceAll(b)
+	}
+
+	syn := syntaxFor(path)
+	for _, p := range w.pairs {
+		var (
+			out  []byte
+			last int
+		)
+		// Each pair can change the tokens seen by the next ones.
+		for _, s := range tokenize(syn, b) {
+			if !w.selects(s.kind) {
+				continue
+			}
+			out = append(out, b[last:s.start]...)
+			out = append(out, p.replaceAll(b[s.start:s.end])...)
+			last = s.end
+		}
+		b = append(out, b[last:]...)
+	}
+	return b
+}
@@ -0,0 +1,147 @@
+package jet
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// TestTokenize tests that the comments and the string literals are found in
+// the supported languages.
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		path     string
+		input    string
+		comments []string
+		strs     []string
+	}{
+		{
+			"a.go",
+			"x := \"a\\\"b\" // c\n/* d\ne */ y := `f\\` + 'g'",
+			[]string{" c", " d\ne "},
+			[]string{`a\"b`, `f\`, "g"},
+		},
+		{
+			"a.py",
+			"s = '''x\n# y''' # z\nt = \"it's\"",
+			[]string{" z"},
+			[]string{"x\n# y", "it's"},
+		},
+		{
+			"a.sh",
+			"echo 'a\\' # b\necho don't",
+			[]string{" b"},
+			[]string{`a\`},
+		},
+		{
+			"Makefile",
+			"all: # build\n\tcc \"main.c\"",
+			[]string{" build"},
+			[]string{"main.c"},
+		},
+		{
+			"a.rs",
+			"fn f<'a>(s: &'a str) {} /* x */",
+			[]string{" x "},
+			nil,
+		},
+		{
+			"a.txt",
+			"// not a comment \"nor a string\"",
+			nil,
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		var comments, strs []string
+		end := 0
+		for _, s := range tokenize(syntaxFor(test.path), []byte(test.input)) {
+			if s.start < end || s.end < s.start {
+				t.Errorf("tokenize(%q): invalid span %v", test.path, s)
+			}
+			end = s.end
+
+			switch s.kind {
+			case commentToken:
+				comments = append(comments, test.input[s.start:s.end])
+			case stringToken:
+				strs = append(strs, test.input[s.start:s.end])
+			}
+		}
+
+		if !equalStrings(comments, test.comments) {
+			t.Errorf("tokenize(%q) comments = %q; want %q", test.path, comments, test.comments)
+		}
+		if !equalStrings(strs, test.strs) {
+			t.Errorf("tokenize(%q) strings = %q; want %q", test.path, strs, test.strs)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestWalkerReplace_Scoped tests that the replacements are only applied to
+// the selected tokens.
+func TestWalkerReplace_Scoped(t *testing.T) {
+	input := "// foo\nfoo := \"foo\" /* foo */\n"
+
+	tests := []struct {
+		walker   walker
+		expected string
+	}{
+		{walker{Options: Options{OnlyComments: true}}, "// bar\nfoo := \"foo\" /* bar */\n"},
+		{walker{Options: Options{OnlyStrings: true}}, "// foo\nfoo := \"bar\" /* foo */\n"},
+		{walker{Options: Options{OnlyCode: true}}, "// foo\nbar := \"foo\" /* foo */\n"},
+		{walker{Options: Options{OnlyComments: true, OnlyStrings: true}}, "// bar\nfoo := \"bar\" /* bar */\n"},
+		{walker{}, "// bar\nbar := \"bar\" /* bar */\n"},
+	}
+
+	for _, test := range tests {
+		w := test.walker
+		w.pairs = pairset{{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")}}
+
+		if result := string(w.replace("main.go", []byte(input))); result != test.expected {
+			t.Errorf("replace with %+v = %q; want %q", test.walker, result, test.expected)
+		}
+	}
+}
+
+// TestWalkerEdit_OnlyComments tests that large files are not streamed when
+// the replacements are scoped.
+func TestWalkerEdit_OnlyComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.py")
+	if err := os.WriteFile(path, []byte("foo = 1  # foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &walker{
+		Options: Options{
+			OnlyComments:    true,
+			StreamThreshold: 1,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+	w.edit(path)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "foo = 1  # bar\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
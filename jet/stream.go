@@ -0,0 +1,380 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	// defaultMaxMatch is the overlap window used when streaming if no
+	// maximum match length is specified.
+	defaultMaxMatch = 4096
+	// chunkSize is the size of the chunks read from the input when streaming.
+	chunkSize = 32 * 1024
+)
+
+// streamer is an io.Writer that replaces the matches of a pair in the data
+// written to it and forwards the result to out.
+// Only the matches starting at least window bytes before the end of the
+// buffered data are replaced, the remaining bytes are kept in the buffer
+// until more data is written or the streamer is closed.
+type streamer struct {
+	pair   pair
+	window int
+	out    io.Writer
+	buf    []byte
+	// ctx is the number of leading bytes in buf that have already been
+	// written out and are only kept as context for the next matches.
+	ctx int
+	// matched reports whether the context ends with a match, in which case
+	// an empty match right after it is ignored, as with ReplaceAll.
+	matched bool
+	// after matches the pattern after the first character of the input.
+	after *regexp.Regexp
+}
+
+func (s *streamer) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	return len(p), s.flush(false)
+}
+
+// Close replaces the matches in the remaining buffered data and writes it out.
+func (s *streamer) Close() error {
+	return s.flush(true)
+}
+
+func (s *streamer) flush(final bool) error {
+	// Matches starting before limit are complete as long as they are not
+	// longer than the window.
+	limit := len(s.buf) - s.window
+	if final {
+		limit = len(s.buf) + 1
+	}
+	if limit <= s.ctx {
+		return nil
+	}
+
+	var (
+		out     []byte
+		last    = s.ctx
+		matched = s.matched
+	)
+	// The matches are searched one at a time like ReplaceAll does, so that
+	// the context is never part of them.
+	for pos := s.ctx; pos <= len(s.buf); {
+		m := s.find(pos)
+		if m == nil || m[0] >= limit {
+			break
+		}
+
+		if m[1] == m[0] {
+			// Empty matches right after another match are ignored.
+			if m[0] == last && matched {
+				pos += runeWidth(s.buf[pos:])
+				continue
+			}
+			pos = m[1] + runeWidth(s.buf[m[1]:])
+		} else {
+			pos = m[1]
+		}
+
+		out = append(out, s.buf[last:m[0]]...)
+		out = s.pair.pattern.Expand(out, s.pair.replacement, s.buf, m)
+		last, matched = m[1], true
+	}
+
+	if final {
+		out = append(out, s.buf[last:]...)
+		last = len(s.buf)
+	} else if last < limit {
+		out = append(out, s.buf[last:limit]...)
+		last, matched = limit, false
+	}
+
+	if _, err := s.out.Write(out); err != nil {
+		return err
+	}
+
+	// Keep the last byte written as context for anchors and word boundaries.
+	s.ctx, s.matched = 0, matched
+	if last > 0 {
+		last--
+		s.ctx = 1
+	}
+	s.buf = append(s.buf[:0], s.buf[last:]...)
+	return nil
+}
+
+// find returns the leftmost match in buf starting at pos or later, with the
+// byte before pos as context.
+func (s *streamer) find(pos int) []int {
+	if pos == 0 {
+		return s.pair.pattern.FindSubmatchIndex(s.buf)
+	}
+
+	b := s.buf[pos-1:]
+	m := s.pair.pattern.FindSubmatchIndex(b)
+	// The match starting on the context byte hides the ones after it.
+	if m != nil && m[0] == 0 {
+		if s.after == nil {
+			s.after = regexp.MustCompile(`\A(?s:.)(?s:.*?)(` + s.pair.pattern.String() + `)`)
+		}
+		if m = s.after.FindSubmatchIndex(b); m != nil {
+			m = m[2:]
+		}
+	}
+	for i := range m {
+		if m[i] >= 0 {
+			m[i] += pos - 1
+		}
+	}
+	return m
+}
+
+// runeWidth returns the width of the first rune in b, or 1 if b is empty.
+func runeWidth(b []byte) int {
+	if len(b) == 0 {
+		return 1
+	}
+	_, width := utf8.DecodeRune(b)
+	return width
+}
+
+// replaceStream writes to dst the content of src with all the pairs applied
+// in order, without loading the whole content in memory.
+// Matches longer than window bytes might not be replaced.
+func (p pairset) replaceStream(dst io.Writer, src io.Reader, window int) error {
+	if window <= 0 {
+		window = defaultMaxMatch
+	}
+
+	// Chain the streamers so that each pair is applied to the output of
+	// the previous one.
+	var (
+		streamers = make([]*streamer, len(p))
+		out       = dst
+	)
+	for i := len(p) - 1; i >= 0; i-- {
+		streamers[i] = &streamer{pair: p[i], window: window, out: out}
+		out = streamers[i]
+	}
+
+	if _, err := io.CopyBuffer(out, src, make([]byte, chunkSize)); err != nil {
+		return err
+	}
+	for _, s := range streamers {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceLines writes to dst the content of src with all the pairs applied
+// to each line separately. The line endings, LF or CRLF, are not part of the
+// lines and are kept as they are.
+func (p pairset) replaceLines(dst io.Writer, src io.Reader) error {
+	r := bufio.NewReader(src)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var eol []byte
+			if bytes.HasSuffix(line, crlf) {
+				line, eol = line[:len(line)-2], line[len(line)-2:]
+			} else if line[len(line)-1] == '\n' {
+				line, eol = line[:len(line)-1], line[len(line)-1:]
+			}
+			if _, err := dst.Write(p.replaceAll(line)); err != nil {
+				return err
+			}
+			if _, err := dst.Write(eol); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// transform writes to dst the content of src with all the pairs applied,
+// either line by line or in chunks, and its line endings converted.
+func (w *walker) transform(dst io.Writer, src io.Reader) error {
+	if w.LineMode {
+		// The lines keep their own line endings unless they are converted.
+		if w.convertsEOL() {
+			dst, src = w.eolStreams(dst, src)
+		}
+		return w.pairs.replaceLines(dst, src)
+	}
+	dst, src = w.eolStreams(dst, src)
+	return w.pairs.replaceStream(dst, src, w.MaxMatch)
+}
+
+// editStream is like edit but it reads and writes the file in chunks.
+func (w *walker) editStream(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	defer f.Close()
+
+	if w.ToStdout && !w.ChangedOnly {
+		w.header(path)
+		if err := w.transform(w.stdout(), f); err != nil {
+			w.fail(err)
+		}
+		return
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	// transform fails with errUnchanged if the content is not changed and
+	// ChangedOnly is set, so that nothing is saved.
+	transform := func(dst io.Writer) error {
+		if !w.ChangedOnly {
+			return w.transform(dst, f)
+		}
+		cmp, err := newCompareWriter(path)
+		if err != nil {
+			return err
+		}
+		defer cmp.Close()
+
+		if err := w.transform(io.MultiWriter(dst, cmp), f); err != nil {
+			return err
+		}
+		if !cmp.changed() {
+			return errUnchanged
+		}
+		return nil
+	}
+
+	switch {
+	case w.ToStdout:
+		err = w.printTemp(path, transform)
+	case w.OutDir != "":
+		var dst string
+		if dst, err = w.outFile(path); err == nil {
+			err = createFile(dst, info.Mode().Perm(), transform)
+		}
+		if err == nil {
+			w.wrote(dst)
+			w.rec.written(path)
+			w.mirror.add(path)
+			err = w.restoreTimes(dst, info)
+		}
+	default:
+		err = replaceFile(path, transform)
+		if err == nil {
+			w.wrote(path)
+			w.rec.written(path)
+			err = w.restoreTimes(path, info)
+		}
+	}
+	if err != nil && err != errUnchanged {
+		w.fail(err)
+	}
+}
+
+// wrote explains that the file at path has been written in verbose mode.
+func (w *walker) wrote(path string) {
+	if w.IsVerbose {
+		fmt.Fprintf(w.stdout(), "writing %s\n", path)
+	}
+}
+
+// replaceFile atomically replaces the content of the file at path with the
+// data written by fn into a temporary file in the same directory.
+// The mode, the ownership and the extended attributes of the file are
+// preserved and symbolic links are followed, so the link target gets replaced.
+func replaceFile(path string, fn func(io.Writer) error) (err error) {
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(path, fn, func(tmp *os.File) error {
+		// Change the mode last since changing the owner clears the setuid bit.
+		if err := copyMetadata(tmp, path, info); err != nil {
+			return err
+		}
+		return tmp.Chmod(info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky))
+	})
+}
+
+// createFile atomically creates or replaces the file at path, with the given
+// permissions, with the data written by fn.
+func createFile(path string, perm fs.FileMode, fn func(io.Writer) error) error {
+	return writeAtomic(path, fn, func(tmp *os.File) error {
+		return tmp.Chmod(perm)
+	})
+}
+
+// writeAtomic writes the data written by fn into a temporary file in the
+// directory of path, sets its metadata with meta and renames it to path.
+// The temporary file is removed if any of them fails.
+func writeAtomic(path string, fn func(io.Writer) error, meta func(*os.File) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".jet*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	if err = fn(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = meta(tmp)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
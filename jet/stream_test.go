@@ -0,0 +1,250 @@
+package jet
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPairsetReplaceStream tests that streaming gives the same result as
+// replacing the whole content at once, including matches across chunks.
+func TestPairsetReplaceStream(t *testing.T) {
+	ps := pairset{
+		{pattern: regexp.MustCompile(`foo(\d+)`), replacement: []byte("bar$1")},
+		{pattern: regexp.MustCompile(`^bar`), replacement: []byte("start")},
+		{pattern: regexp.MustCompile(`\bbaz\b`), replacement: []byte("qux")},
+	}
+
+	// Make sure the matches fall across the chunk boundaries.
+	input := []byte(strings.Repeat("foo123 baz xbaz ", 3*chunkSize/16+7))
+
+	var buf bytes.Buffer
+	if err := ps.replaceStream(&buf, bytes.NewReader(input), 16); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ps.replaceAll(input)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("replaceStream output differs from replaceAll (len %d, want %d)", buf.Len(), len(expected))
+	}
+}
+
+// TestPairsetReplaceStream_Overlap tests that the matches starting on the
+// byte kept as context don't hide the following ones.
+func TestPairsetReplaceStream_Overlap(t *testing.T) {
+	tests := []struct {
+		pattern, replacement, input string
+	}{
+		{"aa", "X", strings.Repeat("a", 100001)},
+		{"aba", "X", strings.Repeat("ab", 50001)},
+		{`\bx*`, "-", strings.Repeat("xx yy ", 10000)},
+		{"a*", "-", strings.Repeat("ab", 30000)},
+	}
+
+	for _, test := range tests {
+		ps := pairset{{pattern: regexp.MustCompile(test.pattern), replacement: []byte(test.replacement)}}
+		for _, window := range []int{1, 2, 16} {
+			var buf bytes.Buffer
+			if err := ps.replaceStream(&buf, strings.NewReader(test.input), window); err != nil {
+				t.Fatal(err)
+			}
+			if expected := ps.replaceAll([]byte(test.input)); !bytes.Equal(buf.Bytes(), expected) {
+				t.Errorf("%q, window %d: replaceStream output differs from replaceAll (len %d, want %d)", test.pattern, window, buf.Len(), len(expected))
+			}
+		}
+	}
+}
+
+// TestPairsetReplaceLines tests the replaceLines method of the pairset type.
+func TestPairsetReplaceLines(t *testing.T) {
+	ps := pairset{
+		{pattern: regexp.MustCompile(`o$`), replacement: []byte("0")},
+		{pattern: regexp.MustCompile(`^f`), replacement: []byte("F")},
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"foo\nfoo\n", "Fo0\nFo0\n"},
+		{"foo\nbar", "Fo0\nbar"},
+		{"", ""},
+		{"\n\n", "\n\n"},
+		{"foo\r\nfoo\n", "Fo0\r\nFo0\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := ps.replaceLines(&buf, strings.NewReader(test.input)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("replaceLines(%q) = %q; want %q", test.input, buf.String(), test.expected)
+		}
+	}
+}
+
+// TestWalkerEdit_Stream tests that files larger than the threshold are
+// streamed and keep their permissions.
+func TestWalkerEdit_Stream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big.txt")
+	if err := os.WriteFile(path, []byte(strings.Repeat("foo ", 1024)), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &walker{
+		Options: Options{
+			StreamThreshold: 10,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		w.edit(path)
+	})
+	if output != "" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != strings.Repeat("bar ", 1024) {
+		t.Errorf("unexpected content after streamed edit: %q...", content[:16])
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected permissions 0640, got %v", info.Mode().Perm())
+	}
+
+	// No temporary file must be left behind.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected 1 file in the directory, got %d", len(entries))
+	}
+}
+
+// TestWalkerEdit_LineModeToStdout tests the line mode when printing to stdout.
+func TestWalkerEdit_LineModeToStdout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	if err := os.WriteFile(path, []byte("a foo\nfoo b\nfoo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &walker{
+		Options: Options{
+			ToStdout: true,
+			LineMode: true,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("^foo$"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		w.edit(path)
+	})
+	if output != "a foo\nfoo b\nbar\n" {
+		t.Errorf("expected only whole line matches replaced, got %q", output)
+	}
+}
+
+// TestWalkerEditStdin_NulBytes tests that stdin is read entirely even when it
+// contains NUL bytes.
+func TestWalkerEditStdin_NulBytes(t *testing.T) {
+	w := &walker{
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	origStdin := os.Stdin
+	defer func() { os.Stdin = origStdin }()
+
+	r, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdin = r
+
+	go func() {
+		wr.Write([]byte("foo\x00foo\x00\x00tail foo"))
+		wr.Close()
+	}()
+
+	output := captureStdout(w.editStdin)
+	if expected := "bar\x00bar\x00\x00tail bar"; output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+// TestWalkerEditStdin_LineModeIncremental tests that in line mode each line
+// is written out before the end of the input.
+func TestWalkerEditStdin_LineModeIncremental(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			LineMode: true,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	origStdin, origStdout := os.Stdin, os.Stdout
+	defer func() {
+		os.Stdin = origStdin
+		os.Stdout = origStdout
+	}()
+
+	rIn, wIn, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rOut, wOut, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdin, os.Stdout = rIn, wOut
+
+	done := make(chan struct{})
+	go func() {
+		w.editStdin()
+		wOut.Close()
+		close(done)
+	}()
+
+	// Keep stdin open while reading the first line of output.
+	wIn.Write([]byte("first foo\n"))
+	line := make(chan string)
+	go func() {
+		l, _ := bufio.NewReader(rOut).ReadString('\n')
+		line <- l
+	}()
+
+	select {
+	case l := <-line:
+		if l != "first bar\n" {
+			t.Errorf("expected %q, got %q", "first bar\n", l)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("line mode output was not written before the end of the input")
+	}
+
+	wIn.Close()
+	<-done
+}
@@ -0,0 +1,965 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// node is a scalar value of a structured file at the given key path.
+// The bytes between start and end are its content, without the quotes.
+type node struct {
+	path  []string
+	start int
+	end   int
+	quote quoting
+}
+
+// format parses a structured file returning its scalar values.
+type format func(b []byte) ([]node, error)
+
+var formats = map[string]format{
+	".json": parseJSON,
+	".yaml": parseYAML,
+	".yml":  parseYAML,
+	".toml": parseTOML,
+}
+
+// formatFor returns the format of the file at path or nil if it is not a
+// supported structured file.
+func formatFor(path string) format {
+	return formats[strings.ToLower(filepath.Ext(path))]
+}
+
+// parseKeyPath splits a key path like "spec.containers[0].image" in its
+// keys. Dots in the keys can be escaped with a backslash.
+func parseKeyPath(s string) (keys []string) {
+	var key strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			key.WriteByte(s[i])
+		case c == '.' || c == '[' || c == ']':
+			if key.Len() > 0 {
+				keys = append(keys, key.String())
+				key.Reset()
+			}
+		default:
+			key.WriteByte(c)
+		}
+	}
+	if key.Len() > 0 {
+		keys = append(keys, key.String())
+	}
+	return
+}
+
+// matchKeyPath reports whether path is inside the node at the key path
+// keys, where "*" matches any key or index.
+func matchKeyPath(keys, path []string) bool {
+	if len(path) < len(keys) {
+		return false
+	}
+	for i, k := range keys {
+		if k != "*" && k != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceKeys returns the content b of the structured file at path with
+// the pairs applied only to the values at the key path in KeyPath.
+// The rest of the file, including its formatting and comments, is left as is.
+func (w *walker) replaceKeys(path string, b []byte) []byte {
+	parse := formatFor(path)
+	if parse == nil {
+		return b
+	}
+
+	nodes, err := parse(b)
+	if err != nil {
+		w.fail(fmt.Errorf("%s: %w", path, err))
+		return b
+	}
+
+	var (
+		keys  = parseKeyPath(w.KeyPath)
+		edits []edit
+	)
+	for _, n := range nodes {
+		if !matchKeyPath(keys, n.path) {
+			continue
+		}
+		// The patterns match the unescaped values.
+		value, err := n.quote.unquote(b[n.start:n.end])
+		if err != nil {
+			w.fail(fmt.Errorf("%s: %s: %w", path, strings.Join(n.path, "."), err))
+			continue
+		}
+		r := w.pairs.replaceAll(value)
+		if bytes.Equal(r, value) {
+			continue
+		}
+
+		text, ok := n.quote.quote(r)
+		if ok {
+			edits = append(edits, edit{n.start, n.end, text})
+			continue
+		}
+		// The strings that can't hold the result are quoted again with
+		// the escapes.
+		q := n.quote.escaped()
+		text, _ = q.quote(r)
+		d := len(n.quote.delim())
+		edits = append(edits, edit{n.start - d, n.end + d, q.delim() + text + q.delim()})
+	}
+	return applyEdits(b, edits)
+}
+
+// flowParser parses JSON, the YAML flow collections and the TOML values.
+type flowParser struct {
+	b []byte
+	i int
+	// sep separates the keys from the values.
+	sep byte
+	// toml enables the TOML multiline strings and dotted keys, yaml the
+	// YAML quoted scalars.
+	toml  bool
+	yaml  bool
+	nodes []node
+}
+
+func parseJSON(b []byte) ([]node, error) {
+	p := &flowParser{b: b, sep: ':'}
+	if err := p.value(nil); err != nil {
+		return nil, err
+	}
+	if p.skip(); p.i < len(b) {
+		return nil, p.errorf("unexpected %q after the end of the document", b[p.i])
+	}
+	return p.nodes, nil
+}
+
+func (p *flowParser) errorf(format string, a ...any) error {
+	line := 1 + bytes.Count(p.b[:p.i], []byte{'\n'})
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, a...))
+}
+
+func (p *flowParser) add(path []string, start, end int) {
+	p.nodes = append(p.nodes, node{path: path, start: start, end: end})
+}
+
+// skip skips the spaces, the newlines and the comments.
+func (p *flowParser) skip() {
+	for p.i < len(p.b) {
+		switch p.b[p.i] {
+		case ' ', '\t', '\r', '\n':
+			p.i++
+		case '#':
+			p.i = lineEnd(p.b, p.i)
+		default:
+			return
+		}
+	}
+}
+
+func (p *flowParser) value(path []string) error {
+	p.skip()
+	if p.i >= len(p.b) {
+		return p.errorf("unexpected end of input")
+	}
+
+	switch p.b[p.i] {
+	case '{':
+		return p.object(path)
+	case '[':
+		return p.array(path)
+	case '"', '\'':
+		start, end, q, err := p.string()
+		if err != nil {
+			return err
+		}
+		p.nodes = append(p.nodes, node{path: path, start: start, end: end, quote: q})
+	default:
+		start := p.i
+		for p.i < len(p.b) && !isFlowEnd(p.b, p.i, start) {
+			p.i++
+		}
+		end := p.i
+		for end > start && isSpace(p.b[end-1]) {
+			end--
+		}
+		if end == start {
+			return p.errorf("unexpected %q", p.b[p.i])
+		}
+		p.add(path, start, end)
+	}
+	return nil
+}
+
+// isFlowEnd reports whether the plain scalar starting at start ends at i.
+func isFlowEnd(b []byte, i, start int) bool {
+	switch b[i] {
+	case ',', ']', '}', '\n':
+		return true
+	case '#':
+		return i > start && isSpace(b[i-1])
+	}
+	return false
+}
+
+func (p *flowParser) object(path []string) error {
+	p.i++
+	for {
+		p.skip()
+		if p.i < len(p.b) && p.b[p.i] == '}' {
+			p.i++
+			return nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return err
+		}
+		if p.skip(); p.i >= len(p.b) || p.b[p.i] != p.sep {
+			return p.errorf("expected %q after key", p.sep)
+		}
+		p.i++
+		if err := p.value(join(path, key...)); err != nil {
+			return err
+		}
+
+		if p.skip(); p.i < len(p.b) && p.b[p.i] == ',' {
+			p.i++
+		} else if p.i >= len(p.b) || p.b[p.i] != '}' {
+			return p.errorf("expected ',' or '}'")
+		}
+	}
+}
+
+func (p *flowParser) array(path []string) error {
+	p.i++
+	for n := 0; ; n++ {
+		p.skip()
+		if p.i < len(p.b) && p.b[p.i] == ']' {
+			p.i++
+			return nil
+		}
+
+		if err := p.value(join(path, strconv.Itoa(n))); err != nil {
+			return err
+		}
+
+		if p.skip(); p.i < len(p.b) && p.b[p.i] == ',' {
+			p.i++
+		} else if p.i >= len(p.b) || p.b[p.i] != ']' {
+			return p.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+// key parses a key, made of more dotted keys in TOML.
+func (p *flowParser) key() (key []string, err error) {
+	for {
+		for p.i < len(p.b) && (p.b[p.i] == ' ' || p.b[p.i] == '\t') {
+			p.i++
+		}
+		if p.i >= len(p.b) {
+			return nil, p.errorf("unexpected end of input")
+		}
+
+		if c := p.b[p.i]; c == '"' || c == '\'' {
+			start, end, q, err := p.string()
+			if err != nil {
+				return nil, err
+			}
+			k, err := q.unquote(p.b[start:end])
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			key = append(key, string(k))
+		} else {
+			start := p.i
+			for p.i < len(p.b) && bytes.IndexByte([]byte{p.sep, '\n', ',', '}', ']'}, p.b[p.i]) < 0 && !(p.toml && p.b[p.i] == '.') {
+				p.i++
+			}
+			k := strings.TrimSpace(string(p.b[start:p.i]))
+			if k == "" {
+				return nil, p.errorf("expected a key")
+			}
+			key = append(key, k)
+		}
+
+		for p.i < len(p.b) && (p.b[p.i] == ' ' || p.b[p.i] == '\t') {
+			p.i++
+		}
+		if !p.toml || p.i >= len(p.b) || p.b[p.i] != '.' {
+			return key, nil
+		}
+		p.i++
+	}
+}
+
+// string parses a quoted string and returns the position of its content
+// and its quoting.
+func (p *flowParser) string() (start, end int, q quoting, err error) {
+	delim := p.b[p.i : p.i+1]
+	if p.toml && (bytes.HasPrefix(p.b[p.i:], []byte(`"""`)) || bytes.HasPrefix(p.b[p.i:], []byte(`'''`))) {
+		delim = p.b[p.i : p.i+3]
+	}
+
+	switch {
+	case p.toml && len(delim) == 3:
+		q = tomlMultiBasic
+		if delim[0] == '\'' {
+			q = tomlMultiLiteral
+		}
+	case p.toml:
+		q = tomlBasic
+		if delim[0] == '\'' {
+			q = tomlLiteral
+		}
+	case p.yaml:
+		q = yamlDouble
+		if delim[0] == '\'' {
+			q = yamlSingle
+		}
+	default:
+		q = jsonString
+	}
+
+	start = p.i + len(delim)
+	// The newline following the opening delimiter of the TOML multiline
+	// strings is not part of their content.
+	if len(delim) == 3 {
+		if bytes.HasPrefix(p.b[start:], []byte("\n")) {
+			start++
+		} else if bytes.HasPrefix(p.b[start:], []byte("\r\n")) {
+			start += 2
+		}
+	}
+	for j := start; j < len(p.b); j++ {
+		switch {
+		case p.b[j] == '\\' && delim[0] == '"':
+			j++
+		case bytes.HasPrefix(p.b[j:], delim):
+			// Two single quotes are an escaped quote in YAML.
+			if !p.toml && delim[0] == '\'' && j+1 < len(p.b) && p.b[j+1] == '\'' {
+				j++
+				continue
+			}
+			p.i = j + len(delim)
+			return start, j, q, nil
+		}
+	}
+	return 0, 0, 0, p.errorf("unterminated string")
+}
+
+func parseTOML(b []byte) ([]node, error) {
+	var (
+		p      = &flowParser{b: b, sep: '=', toml: true}
+		table  []string
+		arrays = make(map[string]int)
+	)
+
+	for {
+		if p.skip(); p.i >= len(b) {
+			return p.nodes, nil
+		}
+
+		if b[p.i] == '[' {
+			array := bytes.HasPrefix(b[p.i:], []byte("[["))
+			close := "]"
+			if array {
+				close = "]]"
+			}
+			p.i += len(close)
+
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.HasPrefix(b[p.i:], []byte(close)) {
+				return nil, p.errorf("expected %q", close)
+			}
+			p.i += len(close)
+			table = tomlTable(key, arrays, array)
+			continue
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if p.i >= len(b) || b[p.i] != '=' {
+			return nil, p.errorf("expected '=' after key")
+		}
+		p.i++
+		if err := p.value(join(table, key...)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tomlTable returns the path of the table with the given key, including
+// the indexes of the elements of the arrays of tables in it.
+func tomlTable(key []string, arrays map[string]int, array bool) (path []string) {
+	for i, k := range key {
+		path = append(path, k)
+
+		id := strings.Join(path, "\x00")
+		if array && i == len(key)-1 {
+			arrays[id]++
+		}
+		if n, ok := arrays[id]; ok {
+			path = append(path, strconv.Itoa(n-1))
+		}
+	}
+	return
+}
+
+// yamlFrame is a block mapping or sequence being parsed.
+type yamlFrame struct {
+	indent int
+	path   []string
+	seq    bool
+	index  int
+	// pending is the path of the value on the following lines, if any.
+	pending []string
+}
+
+// yamlParser parses the block structure of YAML documents, the flow
+// collections and the quoted scalars are parsed by the flowParser.
+type yamlParser struct {
+	flowParser
+	frames []*yamlFrame
+}
+
+func parseYAML(b []byte) ([]node, error) {
+	p := &yamlParser{flowParser: flowParser{b: b, sep: ':', yaml: true}}
+	p.reset()
+
+	for p.i < len(b) {
+		start := p.i
+		end := lineEnd(b, start)
+
+		n := 0
+		for start+n < end && b[start+n] == ' ' {
+			n++
+		}
+		p.i = start + n
+
+		switch line := bytes.TrimRight(b[p.i:end], " \t\r"); {
+		case len(line) == 0 || line[0] == '#' || n == 0 && line[0] == '%':
+			p.skipLine()
+		// Each document has the same key paths.
+		case n == 0 && (isMarker(line, "---") || isMarker(line, "...")):
+			p.reset()
+			p.skipLine()
+		default:
+			if err := p.line(n); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p.nodes, nil
+}
+
+func (p *yamlParser) reset() {
+	p.frames = []*yamlFrame{{indent: -1, pending: []string{}}}
+}
+
+func (p *yamlParser) top() *yamlFrame {
+	return p.frames[len(p.frames)-1]
+}
+
+func (p *yamlParser) push(f *yamlFrame) *yamlFrame {
+	p.top().pending = nil
+	p.frames = append(p.frames, f)
+	return f
+}
+
+// pop closes the collections ended by a line at the given indentation.
+// A sequence can have the same indentation of the key it is the value of.
+func (p *yamlParser) pop(indent int, item bool) {
+	for len(p.frames) > 1 {
+		top := p.top()
+		if top.indent <= indent && !(top.seq && top.indent == indent && !item) {
+			return
+		}
+		p.frames = p.frames[:len(p.frames)-1]
+	}
+}
+
+func (p *yamlParser) skipLine() {
+	p.i = lineEnd(p.b, p.i) + 1
+}
+
+// line parses the content of a line starting at p.i, at the given column.
+func (p *yamlParser) line(col int) error {
+	end := lineEnd(p.b, p.i)
+	item := p.b[p.i] == '-' && (p.i+1 == end || isSpace(p.b[p.i+1]))
+
+	p.pop(col, item)
+	top := p.top()
+
+	if item {
+		seq := top
+		if !top.seq || top.indent != col {
+			if top.pending == nil {
+				p.skipLine()
+				return nil
+			}
+			seq = p.push(&yamlFrame{indent: col, path: top.pending, seq: true, index: -1})
+		}
+		seq.index++
+		seq.pending = join(seq.path, strconv.Itoa(seq.index))
+
+		// The content of the item is parsed as if it was on its own line.
+		p.i++
+		for p.i < end && isSpace(p.b[p.i]) {
+			p.i++
+		}
+		if p.i == end || p.b[p.i] == '#' {
+			p.skipLine()
+			return nil
+		}
+		return p.line(p.i - lineStart(p.b, p.i))
+	}
+
+	if key, ok := p.mapKey(end); ok {
+		m := top
+		if top.indent != col {
+			if top.pending == nil {
+				p.skipLine()
+				return nil
+			}
+			m = p.push(&yamlFrame{indent: col, path: top.pending})
+		}
+		path := join(m.path, key)
+		m.pending = nil
+		pending, err := p.value(path, col)
+		if pending {
+			m.pending = path
+		}
+		return err
+	}
+
+	// A scalar on its own line is the value of the previous key or item,
+	// otherwise it is the continuation of a multiline scalar.
+	if top.indent < col && top.pending != nil {
+		path := top.pending
+		top.pending = nil
+		_, err := p.value(path, col)
+		return err
+	}
+	p.skipLine()
+	return nil
+}
+
+// mapKey parses the key of a block mapping entry ending before end and
+// moves past its colon.
+func (p *yamlParser) mapKey(end int) (string, bool) {
+	b := p.b
+
+	if c := b[p.i]; c == '"' || c == '\'' {
+		i := p.i
+		start, e, q, err := p.string()
+		if err == nil && p.i <= end {
+			for p.i < end && isSpace(b[p.i]) {
+				p.i++
+			}
+			if p.i < end && b[p.i] == ':' && (p.i+1 == end || isSpace(b[p.i+1])) {
+				p.i++
+				if k, err := q.unquote(b[start:e]); err == nil {
+					return string(k), true
+				}
+				return string(b[start:e]), true
+			}
+		}
+		p.i = i
+		return "", false
+	}
+
+	if c := b[p.i]; c == '[' || c == '{' {
+		return "", false
+	}
+	for j := p.i; j < end; j++ {
+		if b[j] == '#' && j > p.i && isSpace(b[j-1]) {
+			break
+		}
+		if b[j] == ':' && (j+1 == end || isSpace(b[j+1])) {
+			key := strings.TrimSpace(string(b[p.i:j]))
+			p.i = j + 1
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// value parses the value at path starting at p.i, in a line with the given
+// indentation, and reports whether it is on the following lines instead.
+func (p *yamlParser) value(path []string, indent int) (pending bool, err error) {
+	end := lineEnd(p.b, p.i)
+	for p.i < end && isSpace(p.b[p.i]) {
+		p.i++
+	}
+	// Skip the anchors and the tags.
+	for p.i < end && (p.b[p.i] == '&' || p.b[p.i] == '!') {
+		for p.i < end && !isSpace(p.b[p.i]) {
+			p.i++
+		}
+		for p.i < end && isSpace(p.b[p.i]) {
+			p.i++
+		}
+	}
+	if p.i == end || p.b[p.i] == '#' {
+		p.skipLine()
+		return true, nil
+	}
+
+	switch p.b[p.i] {
+	case '|', '>':
+		p.block(path, indent)
+	case '[', '{', '"', '\'':
+		if err := p.flowParser.value(path); err != nil {
+			return false, err
+		}
+		p.skipLine()
+	default:
+		start := p.i
+		for p.i < end && !(p.b[p.i] == '#' && isSpace(p.b[p.i-1])) {
+			p.i++
+		}
+		e := p.i
+		for e > start && isSpace(p.b[e-1]) {
+			e--
+		}
+		p.add(path, start, e)
+		p.skipLine()
+	}
+	return false, nil
+}
+
+// block parses the block scalar at path, made of the lines following p.i
+// more indented than indent.
+func (p *yamlParser) block(path []string, indent int) {
+	p.skipLine()
+
+	start, end := -1, -1
+	for p.i < len(p.b) {
+		e := lineEnd(p.b, p.i)
+		n := 0
+		for p.i+n < e && p.b[p.i+n] == ' ' {
+			n++
+		}
+
+		if content := bytes.TrimRight(p.b[p.i+n:e], " \t\r"); len(content) > 0 {
+			if n <= indent {
+				break
+			}
+			if start < 0 {
+				start = p.i + n
+			}
+			end = p.i + n + len(content)
+		}
+		p.i = e + 1
+	}
+	if start >= 0 {
+		p.add(path, start, end)
+	}
+}
+
+// isMarker reports whether line is the given document marker.
+func isMarker(line []byte, marker string) bool {
+	return bytes.HasPrefix(line, []byte(marker)) && (len(line) == len(marker) || isSpace(line[len(marker)]))
+}
+
+// lineStart returns the position of the start of the line containing i.
+func lineStart(b []byte, i int) int {
+	return bytes.LastIndexByte(b[:i], '\n') + 1
+}
+
+// lineEnd returns the position of the end of the line containing i.
+func lineEnd(b []byte, i int) int {
+	if j := bytes.IndexByte(b[i:], '\n'); j >= 0 {
+		return i + j
+	}
+	return len(b)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+// join returns a new path made of path followed by keys.
+func join(path []string, keys ...string) []string {
+	return append(append([]string(nil), path...), keys...)
+}
+
+// quoting is the quoting style of a scalar value.
+type quoting int
+
+const (
+	unquoted quoting = iota
+	jsonString
+	tomlBasic
+	tomlMultiBasic
+	tomlLiteral
+	tomlMultiLiteral
+	yamlDouble
+	yamlSingle
+)
+
+// delim returns the delimiter of the strings quoted with q.
+func (q quoting) delim() string {
+	switch q {
+	case jsonString, tomlBasic, yamlDouble:
+		return `"`
+	case tomlMultiBasic:
+		return `"""`
+	case tomlLiteral, yamlSingle:
+		return `'`
+	case tomlMultiLiteral:
+		return `'''`
+	}
+	return ""
+}
+
+// escaped returns the quoting with escapes equivalent to q, which can hold
+// any value.
+func (q quoting) escaped() quoting {
+	switch q {
+	case tomlLiteral:
+		return tomlBasic
+	case tomlMultiLiteral:
+		return tomlMultiBasic
+	case yamlSingle:
+		return yamlDouble
+	}
+	return q
+}
+
+// unquote returns the value of the content b of a string quoted with q.
+func (q quoting) unquote(b []byte) ([]byte, error) {
+	switch q {
+	case jsonString:
+		var s string
+		if err := json.Unmarshal(append(append([]byte{'"'}, b...), '"'), &s); err != nil {
+			return nil, err
+		}
+		return []byte(s), nil
+	case tomlBasic:
+		return tomlEscapes.unescape(b)
+	case tomlMultiBasic:
+		e := tomlEscapes
+		e.lines = true
+		return e.unescape(b)
+	case yamlDouble:
+		return yamlEscapes.unescape(foldYAML(b, true))
+	case yamlSingle:
+		return bytes.ReplaceAll(foldYAML(b, false), []byte("''"), []byte("'")), nil
+	}
+	return b, nil
+}
+
+// quote returns the content of the string quoted with q holding b, or
+// false if b can't be held without escapes.
+func (q quoting) quote(b []byte) (string, bool) {
+	switch q {
+	case jsonString:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(string(b))
+		s := strings.TrimSpace(buf.String())
+		return s[1 : len(s)-1], true
+	case tomlBasic, tomlMultiBasic, yamlDouble:
+		return escape(b, q == tomlMultiBasic), true
+	case tomlLiteral:
+		return string(b), !bytes.ContainsAny(b, "'\n\r") && !hasControl(b, false)
+	case tomlMultiLiteral:
+		return string(b), !bytes.Contains(b, []byte(`'''`)) && !bytes.HasSuffix(b, []byte("'")) && !hasControl(b, true)
+	case yamlSingle:
+		return strings.ReplaceAll(string(b), "'", "''"), !bytes.ContainsAny(b, "\n\r") && !hasControl(b, false)
+	}
+	return string(b), true
+}
+
+// escapes are the escape sequences of the double quoted strings of a
+// format: the characters following a backslash mapped to the text they
+// stand for, and to the length of the hexadecimal code following them for
+// the Unicode escapes.
+type escapes struct {
+	chars map[byte]string
+	codes map[byte]int
+	// lines enables the backslashes at the end of a line, which remove
+	// the line break and the spaces following it.
+	lines bool
+}
+
+var (
+	tomlEscapes = escapes{
+		chars: map[byte]string{
+			'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", 'e': "\x1b", '"': `"`, '\\': `\`,
+		},
+		codes: map[byte]int{'u': 4, 'U': 8},
+	}
+	yamlEscapes = escapes{
+		chars: map[byte]string{
+			'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v", 'f': "\f",
+			'r': "\r", 'e': "\x1b", ' ': " ", '"': `"`, '/': "/", '\\': `\`,
+			'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+		},
+		codes: map[byte]int{'x': 2, 'u': 4, 'U': 8},
+	}
+)
+
+// unescape returns b with its escape sequences replaced.
+func (e escapes) unescape(b []byte) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\\' {
+			out = append(out, b[i])
+			continue
+		}
+		if i++; i == len(b) {
+			return nil, errors.New("unterminated escape sequence")
+		}
+
+		c := b[i]
+		if s, ok := e.chars[c]; ok {
+			out = append(out, s...)
+			continue
+		}
+		if n, ok := e.codes[c]; ok {
+			if i+n >= len(b) {
+				return nil, fmt.Errorf("invalid escape sequence %q", b[i-1:])
+			}
+			r, err := strconv.ParseUint(string(b[i+1:i+1+n]), 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid escape sequence %q", b[i-1:i+1+n])
+			}
+			out = utf8.AppendRune(out, rune(r))
+			i += n
+			continue
+		}
+		if end := lineEnd(b, i); e.lines && end < len(b) && len(bytes.TrimLeft(b[i:end], " \t\r")) == 0 {
+			for i = end; i+1 < len(b) && (isSpace(b[i+1]) || b[i+1] == '\n'); i++ {
+			}
+			continue
+		}
+		return nil, fmt.Errorf("invalid escape sequence %q", b[i-1:i+1])
+	}
+	return out, nil
+}
+
+// escape returns b escaped for a double quoted string, keeping the line
+// breaks and the tabs if lines is set.
+func escape(b []byte, lines bool) string {
+	var buf strings.Builder
+	for _, r := range string(b) {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case lines && (r == '\n' || r == '\t'):
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&buf, `\u%04x`, r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// hasControl reports whether b contains control characters other than
+// the tabs, and the line breaks if lines is set.
+func hasControl(b []byte, lines bool) bool {
+	for _, c := range b {
+		if (c < 0x20 || c == 0x7f) && c != '\t' && !(lines && (c == '\n' || c == '\r')) {
+			return true
+		}
+	}
+	return false
+}
+
+// foldYAML folds the lines of the content b of a multiline YAML quoted
+// scalar: a line break is a space, or the line breaks of the empty lines
+// following it, and the spaces around it are removed. In the double quoted
+// scalars the line breaks escaped with a backslash are just removed.
+func foldYAML(b []byte, double bool) []byte {
+	if !bytes.ContainsRune(b, '\n') {
+		return b
+	}
+
+	var (
+		out     []byte
+		lines   = bytes.Split(b, lf)
+		empty   int
+		escaped bool
+	)
+	for i, l := range lines {
+		last := i == len(lines)-1
+		if i > 0 {
+			l = bytes.TrimLeft(l, " \t")
+		}
+		if i > 0 && !last && len(bytes.TrimRight(l, " \t\r")) == 0 {
+			empty++
+			continue
+		}
+
+		switch {
+		case i == 0:
+		case empty > 0:
+			out = append(out, bytes.Repeat(lf, empty)...)
+		case !escaped:
+			out = append(out, ' ')
+		}
+		empty = 0
+
+		escaped = false
+		if !last {
+			if n := len(l) - len(bytes.TrimRight(l, `\`)); double && n%2 == 1 {
+				l, escaped = l[:len(l)-1], true
+			} else {
+				l = bytes.TrimRight(l, " \t\r")
+			}
+		}
+		out = append(out, l...)
+	}
+	return out
+}
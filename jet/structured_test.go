@@ -0,0 +1,243 @@
+package jet
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// nodeValues returns the values of the nodes by their joined key path.
+func nodeValues(b []byte, nodes []node) map[string]string {
+	values := make(map[string]string)
+	for _, n := range nodes {
+		values[strings.Join(n.path, ".")] = string(b[n.start:n.end])
+	}
+	return values
+}
+
+// TestParseStructured tests that the values of the structured files are
+// found at their key paths.
+func TestParseStructured(t *testing.T) {
+	tests := []struct {
+		parse    format
+		input    string
+		expected map[string]string
+	}{
+		{
+			parseJSON,
+			`{"a": {"b": "x\"y", "c": [1, true, null]}, "d": -1.5e3}`,
+			map[string]string{"a.b": `x\"y`, "a.c.0": "1", "a.c.1": "true", "a.c.2": "null", "d": "-1.5e3"},
+		},
+		{
+			parseTOML,
+			`title = "t" # comment
+[server]
+host.name = 'localhost'
+ports = [ 80,
+  443 ]
+[[servers]]
+ip = "10.0.0.1"
+[[servers]]
+ip = """10.0.0.2"""
+opts = { tls = true, "a.b" = 1979-05-27T07:32:00Z }
+`,
+			map[string]string{
+				"title": "t", "server.host.name": "localhost", "server.ports.0": "80", "server.ports.1": "443",
+				"servers.0.ip": "10.0.0.1", "servers.1.ip": "10.0.0.2", "servers.1.opts.tls": "true",
+				"servers.1.opts.a.b": "1979-05-27T07:32:00Z",
+			},
+		},
+		{
+			parseYAML,
+			`# comment
+spec:
+  template:
+    image: nginx:1.0 # comment
+    name: "web"
+  containers:
+  - name: a
+    args: [--x, "y z"]
+  - name: 'it''s'
+    env:
+      - one
+      -
+        two
+  script: |
+    echo a
+    echo b
+
+  flow: {k: v, n: [1, 2]}
+top: &anchor !!str value
+---
+top: second
+`,
+			map[string]string{
+				"spec.template.image": "nginx:1.0", "spec.template.name": "web",
+				"spec.containers.0.name": "a", "spec.containers.0.args.0": "--x", "spec.containers.0.args.1": "y z",
+				"spec.containers.1.name": "it''s", "spec.containers.1.env.0": "one", "spec.containers.1.env.1": "two",
+				"spec.script": "echo a\n    echo b", "spec.flow.k": "v", "spec.flow.n.0": "1", "spec.flow.n.1": "2",
+				"top": "second",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		nodes, err := test.parse([]byte(test.input))
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %v", test.input, err)
+			continue
+		}
+		if values := nodeValues([]byte(test.input), nodes); !reflect.DeepEqual(values, test.expected) {
+			t.Errorf("unexpected values parsing %q:\n%v\nwant:\n%v", test.input, values, test.expected)
+		}
+	}
+}
+
+// TestParseJSON_Invalid tests that the malformed JSON files are reported.
+func TestParseJSON_Invalid(t *testing.T) {
+	for _, input := range []string{`{"a": 1`, `{"a" 1}`, `["a"] x`, `{"a": "b}`} {
+		if _, err := parseJSON([]byte(input)); err == nil {
+			t.Errorf("expected an error parsing %q", input)
+		}
+	}
+}
+
+// TestParseKeyPath tests the parseKeyPath function.
+func TestParseKeyPath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"spec.template.image", []string{"spec", "template", "image"}},
+		{"containers[0].image", []string{"containers", "0", "image"}},
+		{`a\.b.*`, []string{"a.b", "*"}},
+	}
+
+	for _, test := range tests {
+		if result := parseKeyPath(test.input); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("parseKeyPath(%q) = %q; want %q", test.input, result, test.expected)
+		}
+	}
+}
+
+// TestWalkerReplaceKeys tests that only the values at the key path are
+// replaced and the rest of the file is left as is.
+func TestWalkerReplaceKeys(t *testing.T) {
+	tests := []struct {
+		path     string
+		key      string
+		input    string
+		expected string
+	}{
+		{
+			"deploy.yaml",
+			"spec.containers.*.image",
+			"spec:\n  containers:\n    - image: nginx:1.0   # pinned\n      name: nginx:1.0\n    - image: \"nginx:1.0\"\nimage: nginx:1.0\n",
+			"spec:\n  containers:\n    - image: nginx:1.25   # pinned\n      name: nginx:1.0\n    - image: \"nginx:1.25\"\nimage: nginx:1.0\n",
+		},
+		{
+			"package.json",
+			"dependencies",
+			"{\n    \"name\": \"nginx:1.0\",\n    \"dependencies\": {\"a\": \"nginx:1.0\"}\n}\n",
+			"{\n    \"name\": \"nginx:1.0\",\n    \"dependencies\": {\"a\": \"nginx:1.25\"}\n}\n",
+		},
+		{
+			"Cargo.toml",
+			"package.version",
+			"[package]\nname = \"nginx:1.0\"\nversion = \"nginx:1.0\" # bump\n",
+			"[package]\nname = \"nginx:1.0\"\nversion = \"nginx:1.25\" # bump\n",
+		},
+		{
+			"notes.txt",
+			"image",
+			"image: nginx:1.0\n",
+			"image: nginx:1.0\n",
+		},
+	}
+
+	for _, test := range tests {
+		w := &walker{
+			Options: Options{
+				KeyPath: test.key,
+			},
+			pairs: pairset{
+				{pattern: regexp.MustCompile(`nginx:[\d.]+`), replacement: []byte("nginx:1.25")},
+			},
+		}
+		if result := string(w.replace(test.path, []byte(test.input))); result != test.expected {
+			t.Errorf("replace(%q) with key %q = %q; want %q", test.path, test.key, result, test.expected)
+		}
+	}
+}
+
+// TestQuotingUnquote tests that the quoted strings are unescaped and that
+// the values are escaped back.
+func TestQuotingUnquote(t *testing.T) {
+	tests := []struct {
+		quote quoting
+		raw   string
+		value string
+	}{
+		{jsonString, `a\"b\\c\u00e9\n`, "a\"b\\c\u00e9\n"},
+		{tomlBasic, `a\"b\\c\u00e9\U0001F600\t`, "a\"b\\c\u00e9\U0001F600\t"},
+		{tomlMultiBasic, "a\\\n   b\nc", "ab\nc"},
+		{tomlLiteral, `a\b`, `a\b`},
+		{yamlDouble, `a\"b\x41\_`, "a\"bA\u00a0"},
+		{yamlDouble, "a\n  b\n\n  c \\\n  d", "a b\nc d"},
+		{yamlSingle, "it''s\n  fine", "it's fine"},
+	}
+
+	for _, test := range tests {
+		value, err := test.quote.unquote([]byte(test.raw))
+		if err != nil {
+			t.Errorf("unquote(%q) with %d: unexpected error %v", test.raw, test.quote, err)
+			continue
+		}
+		if string(value) != test.value {
+			t.Errorf("unquote(%q) with %d = %q; want %q", test.raw, test.quote, value, test.value)
+		}
+
+		raw, ok := test.quote.quote(value)
+		if !ok {
+			continue
+		}
+		if back, err := test.quote.unquote([]byte(raw)); err != nil || string(back) != test.value {
+			t.Errorf("quote(%q) with %d = %q, which is %q, %v", test.value, test.quote, raw, back, err)
+		}
+	}
+
+	if _, err := tomlBasic.unquote([]byte(`\q`)); err == nil {
+		t.Errorf("expected an error for an invalid escape")
+	}
+}
+
+// TestWalkerReplaceKeys_Escape tests that the replacements are escaped for
+// the quoting of the values, which are quoted again if needed.
+func TestWalkerReplaceKeys_Escape(t *testing.T) {
+	tests := []struct {
+		path     string
+		input    string
+		expected string
+	}{
+		{"a.json", `{"image": "nginx:1.0"}`, `{"image": "nginx:'2.0\"\\"}`},
+		{"a.toml", `image = "nginx:1.0"`, `image = "nginx:'2.0\"\\"`},
+		{"a.toml", `image = 'nginx:1.0'`, `image = "nginx:'2.0\"\\"`},
+		{"a.yaml", `image: "nginx:1.0"`, `image: "nginx:'2.0\"\\"`},
+		{"a.yaml", `image: 'nginx:1.0'`, `image: 'nginx:''2.0"\'`},
+	}
+
+	for _, test := range tests {
+		w := &walker{
+			Options: Options{
+				KeyPath: "image",
+			},
+			pairs: pairset{
+				{pattern: regexp.MustCompile(`1\.0`), replacement: []byte(`'2.0"\`)},
+			},
+		}
+		if result := string(w.replace(test.path, []byte(test.input))); result != test.expected {
+			t.Errorf("replace(%q) in %s = %q; want %q", test.input, test.path, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func isSymlink(d fs.DirEntry) bool {
+	return d.Type()&fs.ModeSymlink != 0
+}
+
+// isInside reports whether path is root or is under the directory root.
+func isInside(path, root string) bool {
+	return path == root || strings.HasPrefix(path, root+string(os.PathSeparator))
+}
+
+// fileKey identifies a file regardless of the path used to reach it, either
+// by its device and inode numbers or by its resolved path.
+type fileKey struct {
+	dev  uint64
+	ino  uint64
+	path string
+}
+
+// pathKey returns the key of the file at path based on its resolved path.
+func pathKey(path string) fileKey {
+	if real, err := realPath(path); err == nil {
+		path = real
+	}
+	return fileKey{path: path}
+}
+
+// setRoots stores the resolved paths of the roots of the walk.
+// If no root is given the current directory is used.
+func (w *walker) setRoots(paths ...string) {
+	w.roots = nil
+	for _, p := range paths {
+		if p == "-" {
+			continue
+		}
+		if real, err := realPath(p); err == nil {
+			w.roots = append(w.roots, real)
+		}
+	}
+
+	if len(w.roots) == 0 {
+		if real, err := realPath("."); err == nil {
+			w.roots = append(w.roots, real)
+		}
+	}
+}
+
+// realPath returns the absolute path of path with all the symbolic links resolved.
+func realPath(path string) (string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// insideRoots reports whether the file at path, once all the symbolic links
+// are resolved, is inside one of the roots of the walk.
+func (w *walker) insideRoots(path string) bool {
+	real, err := realPath(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range w.roots {
+		if isInside(real, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// editable reports whether the content of the file at path can be edited
+// according to the symbolic links policy.
+func (w *walker) editable(path string, d fs.DirEntry) bool {
+	if isSymlink(d) {
+		if w.NoFollow {
+			return false
+		}
+		// Only edit the links to regular files.
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			return false
+		}
+		// The links are copied to OutDir as links, their targets are edited
+		// at their own paths.
+		if w.OutDir != "" {
+			return false
+		}
+	}
+
+	if w.WithinRoots && !w.insideRoots(path) {
+		if w.IsVerbose {
+			fmt.Fprintf(w.stdout(), "skipping %s: outside of the given paths\n", path)
+		}
+		return false
+	}
+	return true
+}
+
+// walkLink walks the directory pointed by the symbolic link at path, reporting
+// the paths of its files as if they were under the link.
+// Links to one of their parent directories and links to directories that
+// have already been walked are skipped.
+func (w *walker) walkLink(path string) {
+	if w.MaxDepth >= 0 && depth(path) > w.MaxDepth {
+		return
+	}
+
+	target, err := realPath(path)
+	if err != nil {
+		// Dangling link.
+		return
+	}
+	if info, err := os.Stat(target); err != nil || !info.IsDir() {
+		return
+	}
+
+	parent, err := realPath(filepath.Dir(path))
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	if isInside(parent, target) {
+		if w.IsVerbose {
+			fmt.Fprintf(w.stdout(), "skipping %s: symbolic link cycle\n", path)
+		}
+		return
+	}
+
+	if w.followed == nil {
+		w.followed = make(map[string]bool)
+	}
+	if w.followed[target] {
+		return
+	}
+	w.followed[target] = true
+
+	// The paths under the target are reported as if they were under the link.
+	linked := func(p string) string {
+		rel, err := filepath.Rel(target, p)
+		if err != nil {
+			return p
+		}
+		return filepath.Join(path, rel)
+	}
+	err = walkDir(target, func(p string, d fs.DirEntry, err error) error {
+		// The link itself has already been processed.
+		if p == target {
+			return err
+		}
+		return w.processFile(linked(p), d, err)
+	}, func(p string) bool {
+		return w.descends(linked(p))
+	})
+	if err != nil {
+		w.fail(err)
+	}
+}
@@ -0,0 +1,158 @@
+package jet
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// newSymlinkTree creates the following tree and returns the paths of root
+// and outside:
+//
+//	root/file.txt
+//	root/dir/inner.txt
+//	root/link.txt -> outside/file.txt
+//	root/linkdir -> outside/dir
+//	root/dir/loop -> root
+//	outside/file.txt
+//	outside/dir/nested.txt
+func newSymlinkTree(t *testing.T) (root, outside string) {
+	t.Helper()
+
+	tmp := newTree(t, map[string]string{
+		"root/file.txt":          "foo",
+		"root/dir/inner.txt":     "foo",
+		"outside/file.txt":       "foo",
+		"outside/dir/nested.txt": "foo",
+	})
+	root = filepath.Join(tmp, "root")
+	outside = filepath.Join(tmp, "outside")
+
+	links := map[string]string{
+		filepath.Join(root, "link.txt"):    filepath.Join(outside, "file.txt"),
+		filepath.Join(root, "linkdir"):     filepath.Join(outside, "dir"),
+		filepath.Join(root, "dir", "loop"): root,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symbolic links not supported: %v", err)
+		}
+	}
+	return root, outside
+}
+
+func newSymlinkWalker() *walker {
+	return &walker{
+		Options: Options{
+			Glob:     "*",
+			MaxDepth: -1,
+		},
+		WaitGroup: new(sync.WaitGroup),
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+}
+
+func checkContents(t *testing.T, expected map[string]string) {
+	t.Helper()
+
+	for path, want := range expected {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("expected %s to contain %q, got %q", path, want, content)
+		}
+	}
+}
+
+// TestWalkerSymlinks_Default tests that by default links to files are
+// followed while links to directories are not.
+func TestWalkerSymlinks_Default(t *testing.T) {
+	root, outside := newSymlinkTree(t)
+
+	w := newSymlinkWalker()
+	output := captureStdout(func() {
+		w.Walk(root)
+	})
+	if output != "" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	checkContents(t, map[string]string{
+		filepath.Join(root, "file.txt"):             "bar",
+		filepath.Join(root, "dir", "inner.txt"):     "bar",
+		filepath.Join(outside, "file.txt"):          "bar",
+		filepath.Join(outside, "dir", "nested.txt"): "foo",
+	})
+
+	// The link must still be a link.
+	if info, err := os.Lstat(filepath.Join(root, "link.txt")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected link.txt to still be a symbolic link")
+	}
+}
+
+// TestWalkerSymlinks_NoFollow tests that with NoFollow the links are renamed
+// but their targets are left untouched.
+func TestWalkerSymlinks_NoFollow(t *testing.T) {
+	root, outside := newSymlinkTree(t)
+
+	w := newSymlinkWalker()
+	w.NoFollow = true
+	w.ReplaceNames = true
+	w.pairs = append(w.pairs, pair{pattern: regexp.MustCompile("^link"), replacement: []byte("renamed")})
+	w.Walk(root)
+
+	checkContents(t, map[string]string{
+		filepath.Join(root, "file.txt"):    "bar",
+		filepath.Join(outside, "file.txt"): "foo",
+	})
+
+	info, err := os.Lstat(filepath.Join(root, "renamed.txt"))
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected the link to be renamed to renamed.txt")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "file.txt")); err != nil {
+		t.Errorf("expected the link target not to be renamed: %v", err)
+	}
+}
+
+// TestWalkerSymlinks_Follow tests that with FollowSymlinks the linked
+// directories are walked and the cycles are detected.
+func TestWalkerSymlinks_Follow(t *testing.T) {
+	root, outside := newSymlinkTree(t)
+
+	w := newSymlinkWalker()
+	w.FollowSymlinks = true
+	w.pairs = pairset{{pattern: regexp.MustCompile("foo"), replacement: []byte("${0}o")}}
+	w.Walk(root)
+
+	// Each file must be edited exactly once despite the loop.
+	checkContents(t, map[string]string{
+		filepath.Join(root, "file.txt"):             "fooo",
+		filepath.Join(root, "dir", "inner.txt"):     "fooo",
+		filepath.Join(outside, "dir", "nested.txt"): "fooo",
+	})
+}
+
+// TestWalkerSymlinks_WithinRoots tests that with WithinRoots the files
+// outside of the given paths are not edited.
+func TestWalkerSymlinks_WithinRoots(t *testing.T) {
+	root, outside := newSymlinkTree(t)
+
+	w := newSymlinkWalker()
+	w.FollowSymlinks = true
+	w.WithinRoots = true
+	w.Walk(root)
+
+	checkContents(t, map[string]string{
+		filepath.Join(root, "file.txt"):             "bar",
+		filepath.Join(root, "dir", "inner.txt"):     "bar",
+		filepath.Join(outside, "file.txt"):          "foo",
+		filepath.Join(outside, "dir", "nested.txt"): "foo",
+	})
+}
@@ -0,0 +1,90 @@
+//go:build linux
+
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// copyXattrs copies the extended attributes, ACLs included, of the file at
+// src to the file at dst.
+func copyXattrs(dst, src string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		size, err := syscall.Getxattr(src, name, nil)
+		if err != nil {
+			return &fs.PathError{Op: "getxattr", Path: src, Err: err}
+		}
+		value := make([]byte, size)
+		if size, err = syscall.Getxattr(src, name, value); err != nil {
+			return &fs.PathError{Op: "getxattr", Path: src, Err: err}
+		}
+
+		if err := syscall.Setxattr(dst, name, value[:size], 0); err != nil {
+			// Skip the namespaces the user has no access to, like "trusted".
+			if err == syscall.EPERM || err == syscall.ENOTSUP {
+				continue
+			}
+			return &fs.PathError{Op: "setxattr", Path: dst, Err: err}
+		}
+	}
+	return nil
+}
+
+// listXattrs returns the names of the extended attributes of the file at path.
+func listXattrs(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err == syscall.ENOTSUP {
+		return nil, nil
+	} else if err != nil {
+		return nil, &fs.PathError{Op: "listxattr", Path: path, Err: err}
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, &fs.PathError{Op: "listxattr", Path: path, Err: err}
+	}
+	return strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00"), nil
+}
+
+// mapFile maps the first size bytes of f in memory, read only.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	b, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &fs.PathError{Op: "mmap", Path: f.Name(), Err: err}
+	}
+	return b, nil
+}
+
+// unmapFile unmaps the memory returned by mapFile.
+func unmapFile(b []byte) error {
+	return syscall.Munmap(b)
+}
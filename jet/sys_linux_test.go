@@ -0,0 +1,208 @@
+package jet
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestReplaceFile_Metadata tests that replacing a file preserves the special
+// mode bits and the extended attributes.
+func TestReplaceFile_Metadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0755|os.ModeSetgid|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+
+	hasXattr := true
+	if err := syscall.Setxattr(path, "user.jet", []byte("value"), 0); err != nil {
+		hasXattr = false
+		t.Logf("extended attributes not supported: %v", err)
+	}
+
+	err := replaceFile(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "bar")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 0755 | os.ModeSetgid | os.ModeSetuid
+	if info.Mode() != expected {
+		t.Errorf("expected mode %v, got %v", expected, info.Mode())
+	}
+
+	if hasXattr {
+		value := make([]byte, 16)
+		n, err := syscall.Getxattr(path, "user.jet", value)
+		if err != nil {
+			t.Fatalf("extended attribute not preserved: %v", err)
+		}
+		if string(value[:n]) != "value" {
+			t.Errorf("expected extended attribute 'value', got %q", value[:n])
+		}
+	}
+}
+
+// TestWalkerEdit_Metadata tests that the files edited in memory are
+// replaced preserving the special mode bits and the extended attributes.
+func TestWalkerEdit_Metadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0755|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	hasXattr := syscall.Setxattr(path, "user.jet", []byte("value"), 0) == nil
+
+	w := newRenameWalker(pair{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")})
+	w.ReplaceNames = false
+	w.Walk(path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 0755 | os.ModeSetgid; info.Mode() != expected {
+		t.Errorf("expected mode %v, got %v", expected, info.Mode())
+	}
+	if hasXattr {
+		if _, err := syscall.Getxattr(path, "user.jet", nil); err != nil {
+			t.Errorf("extended attribute not preserved: %v", err)
+		}
+	}
+	if content, _ := os.ReadFile(path); string(content) != "bar" {
+		t.Errorf("expected content 'bar', got %q", content)
+	}
+}
+
+// TestListXattrs tests the listXattrs function.
+func TestListXattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := listXattrs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("expected no extended attributes, got %v", names)
+	}
+
+	if err := syscall.Setxattr(path, "user.a", []byte("1"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+	syscall.Setxattr(path, "user.b", []byte("2"), 0)
+
+	names, err = listXattrs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, ","); !strings.Contains(got, "user.a") || !strings.Contains(got, "user.b") {
+		t.Errorf("expected user.a and user.b, got %v", names)
+	}
+}
+
+// TestWalkerSkippable tests that the large UTF-8 files without the required
+// literals are skipped without being read.
+func TestWalkerSkippable(t *testing.T) {
+	large := strings.Repeat("lorem ipsum dolor\n", mmapThreshold/16)
+	tests := []struct {
+		name     string
+		content  string
+		toStdout bool
+		expected bool
+	}{
+		{"nomatch.txt", large, false, true},
+		{"match.txt", large + "needle\n", false, false},
+		{"small.txt", "lorem ipsum\n", false, false},
+		{"stdout.txt", large, true, false},
+		{"utf16.txt", string(utf16LE(large)), false, false},
+		{"latin1.txt", large + "caf\xe9\n", false, false},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := &walker{
+			Options: Options{
+				ToStdout: test.toStdout,
+			},
+			filter: newPrefilter(pairset{{pattern: regexp.MustCompile(`needle`)}}),
+		}
+		if got := w.skippable(path, info); got != test.expected {
+			t.Errorf("skippable(%s) = %t; want %t", test.name, got, test.expected)
+		}
+	}
+}
+
+// BenchmarkSkippable compares the allocations of checking the files of a
+// synthetic tree with the prefilter, memory mapping them and reading them.
+func BenchmarkSkippable(b *testing.B) {
+	var (
+		dir   = b.TempDir()
+		paths []string
+		size  int64
+	)
+	for i, f := range syntheticTree(64, 1<<20) {
+		path := filepath.Join(dir, fmt.Sprintf("f%02d.txt", i))
+		if err := os.WriteFile(path, f, 0644); err != nil {
+			b.Fatal(err)
+		}
+		paths = append(paths, path)
+		size += int64(len(f))
+	}
+
+	w := &walker{filter: newPrefilter(benchmarkPairs["literal"])}
+
+	b.Run("mmap", func(b *testing.B) {
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				info, err := os.Stat(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				w.skippable(path, info)
+			}
+		}
+	})
+	b.Run("read", func(b *testing.B) {
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				w.filter.match(content)
+			}
+		}
+	})
+}
@@ -0,0 +1,44 @@
+//go:build !unix
+
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"io/fs"
+	"os"
+)
+
+// fileID returns the key identifying the file at path, since inode numbers
+// are not available it relies on its resolved path.
+func fileID(path string, info fs.FileInfo) fileKey {
+	return pathKey(path)
+}
+
+// linkCount always returns 1 on systems without inodes, where the hard links
+// are not detected.
+func linkCount(info fs.FileInfo) uint64 {
+	return 1
+}
+
+// copyMetadata is a no-op on systems without file ownership, where only the
+// file mode is preserved.
+func copyMetadata(dst *os.File, src string, info fs.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,46 @@
+//go:build !linux
+
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoMmap is returned by mapFile on the systems where the files are not
+// memory mapped.
+var errNoMmap = errors.New("memory mapping not supported")
+
+// copyXattrs is a no-op on systems other than Linux, where the extended
+// attributes are not copied.
+func copyXattrs(dst, src string) error {
+	return nil
+}
+
+// mapFile always fails on systems other than Linux, where the files are read.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+// unmapFile is a no-op on systems other than Linux.
+func unmapFile(b []byte) error {
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// fileID returns the key identifying the file at path described by info,
+// which is the same for all the hard links to the file.
+func fileID(path string, info fs.FileInfo) fileKey {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+	}
+	return pathKey(path)
+}
+
+// linkCount returns the number of hard links to the file described by info.
+func linkCount(info fs.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// copyMetadata copies to dst the ownership and the extended attributes of
+// the file at src described by info.
+func copyMetadata(dst *os.File, src string, info fs.FileInfo) error {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		// Only root can give a file to another user, the file is then
+		// owned by the user editing it.
+		if err := dst.Chown(int(st.Uid), int(st.Gid)); err != nil && !errors.Is(err, syscall.EPERM) {
+			return err
+		}
+	}
+	return copyXattrs(dst.Name(), src)
+}
@@ -0,0 +1,241 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxDirReaders is the number of directories read concurrently.
+	maxDirReaders = 16
+	// maxReadAhead is the maximum number of directories read ahead of the
+	// walk and not visited yet.
+	maxReadAhead = 1024
+)
+
+type dirStatus int
+
+const (
+	dirQueued dirStatus = iota
+	dirReading
+	dirDone
+)
+
+// dir is a directory read ahead of the walk.
+type dir struct {
+	status    dirStatus
+	entries   []fs.DirEntry
+	err       error
+	discarded bool
+}
+
+// dirReader reads the directories of a tree concurrently, ahead of a walk
+// visiting them one at a time.
+// The subdirectories of each directory read are queued in turn, the first
+// ones in lexical order being read first, so that they are usually ready by
+// the time the walk gets to them.
+type dirReader struct {
+	// want reports whether the walk descends into the directory at path,
+	// the other ones are not read.
+	want   func(path string) bool
+	mu     sync.Mutex
+	cond   *sync.Cond
+	dirs   map[string]*dir
+	stack  []string
+	ready  int
+	closed bool
+}
+
+func newDirReader(want func(string) bool) *dirReader {
+	r := &dirReader{want: want, dirs: make(map[string]*dir)}
+	r.cond = sync.NewCond(&r.mu)
+	for i := 0; i < maxDirReaders; i++ {
+		go r.work()
+	}
+	return r
+}
+
+// close stops the readers.
+func (r *dirReader) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.cond.Broadcast()
+}
+
+func (r *dirReader) work() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		for !r.closed && (len(r.stack) == 0 || r.ready >= maxReadAhead) {
+			r.cond.Wait()
+		}
+		if r.closed {
+			return
+		}
+
+		path := r.stack[len(r.stack)-1]
+		r.stack = r.stack[:len(r.stack)-1]
+		// The directory might have been read by the walk or discarded.
+		if d := r.dirs[path]; d != nil && d.status == dirQueued {
+			r.readDir(path, d)
+		}
+	}
+}
+
+// readDir reads the directory at path and queues its subdirectories.
+// It must be called with the lock held, which is released while reading.
+func (r *dirReader) readDir(path string, d *dir) {
+	d.status = dirReading
+	r.mu.Unlock()
+	entries, err := os.ReadDir(path)
+	r.mu.Lock()
+
+	d.status, d.entries, d.err = dirDone, entries, err
+	if !d.discarded {
+		r.ready++
+		for i := len(entries) - 1; i >= 0; i-- {
+			sub := filepath.Join(path, entries[i].Name())
+			if entries[i].IsDir() && r.dirs[sub] == nil && r.want(sub) {
+				r.dirs[sub] = &dir{}
+				r.stack = append(r.stack, sub)
+			}
+		}
+	}
+	r.cond.Broadcast()
+}
+
+// read returns the entries of the directory at path, reading it right away
+// if it has not been read yet.
+func (r *dirReader) read(path string) ([]fs.DirEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := r.dirs[path]
+	if d == nil {
+		d = &dir{}
+		r.dirs[path] = d
+	}
+	if d.status == dirQueued {
+		r.readDir(path, d)
+	}
+	for d.status != dirDone {
+		r.cond.Wait()
+	}
+
+	delete(r.dirs, path)
+	r.ready--
+	return d.entries, d.err
+}
+
+// discard forgets the directory at path and its subdirectories, which are
+// not visited by the walk.
+func (r *dirReader) discard(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prefix := path + string(os.PathSeparator)
+	for p, d := range r.dirs {
+		if p != path && !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		if d.status == dirDone && !d.discarded {
+			r.ready--
+		}
+		d.discarded = true
+		delete(r.dirs, p)
+	}
+	r.cond.Broadcast()
+}
+
+// walk visits the tree at path like filepath.WalkDir.
+func (r *dirReader) walk(path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			r.discard(path)
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := r.read(path)
+	if err != nil {
+		// Give fn a chance to skip the directory, the entries read before
+		// the error are visited otherwise.
+		if err = fn(path, d, err); err != nil {
+			if err == fs.SkipDir && d.IsDir() {
+				r.discard(path)
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for i, e := range entries {
+		if err := r.walk(filepath.Join(path, e.Name()), e, fn); err != nil {
+			if err == fs.SkipDir {
+				// Skip the remaining files in the directory.
+				for _, e := range entries[i+1:] {
+					if e.IsDir() {
+						r.discard(filepath.Join(path, e.Name()))
+					}
+				}
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDir is like filepath.WalkDir, calling fn on the same files in the same
+// lexical order, but it reads the directories concurrently ahead of the
+// walk, which is faster on network file systems and on large trees.
+// want reports whether the walk descends into a directory, so that the
+// skipped ones are not read.
+func walkDir(root string, fn fs.WalkDirFunc, want func(string) bool) error {
+	r := newDirReader(want)
+	defer r.close()
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = r.walk(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// descends reports whether the walk descends into the directory at path,
+// given the depth and hidden files rules.
+func (w *walker) descends(path string) bool {
+	if w.MaxDepth >= 0 && depth(path) > w.MaxDepth {
+		return false
+	}
+	return w.IncludeHidden || !isHidden(filepath.Base(path))
+}
@@ -0,0 +1,138 @@
+package jet
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dirTree returns the files of a tree of directories with the given fan out
+// and depth, each containing a couple of empty files.
+func dirTree(fanout, levels int) map[string]string {
+	files := make(map[string]string)
+
+	var add func(dir string, level int)
+	add = func(dir string, level int) {
+		for _, name := range []string{"a.txt", "z.txt"} {
+			files[path.Join(dir, name)] = ""
+		}
+		if level == levels {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			add(path.Join(dir, fmt.Sprintf("d%d", i)), level+1)
+		}
+	}
+	add(".", 0)
+	return files
+}
+
+// visits returns the visits of walk, calling fn on each of them.
+func visits(walk func(string, fs.WalkDirFunc) error, root string, fn func(string, fs.DirEntry) error) ([]string, error) {
+	var v []string
+	err := walk(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			v = append(v, "error "+path)
+			return nil
+		}
+		v = append(v, path)
+		return fn(path, d)
+	})
+	return v, err
+}
+
+// TestWalkDir tests that walkDir visits the same files in the same order as
+// filepath.WalkDir, honoring the skipped directories and files.
+func TestWalkDir(t *testing.T) {
+	files := dirTree(4, 3)
+	for _, name := range []string{"d1/.hidden/x.txt", "d2/skip/x.txt", "d3/b.txt", "d3/stop.txt"} {
+		files[name] = ""
+	}
+	root := newTree(t, files)
+
+	tests := []struct {
+		name string
+		fn   func(string, fs.DirEntry) error
+		want func(string) bool
+	}{
+		{"all", func(string, fs.DirEntry) error { return nil }, func(string) bool { return true }},
+		{"skip", func(path string, d fs.DirEntry) error {
+			switch {
+			case d.IsDir() && (d.Name() == "skip" || d.Name() == ".hidden"):
+				return fs.SkipDir
+			case d.Name() == "stop.txt":
+				return fs.SkipDir
+			}
+			return nil
+		}, func(path string) bool { return !isHidden(filepath.Base(path)) }},
+		{"skip all", func(path string, d fs.DirEntry) error {
+			if strings.HasSuffix(path, filepath.Join("d2", "d1")) {
+				return fs.SkipAll
+			}
+			return nil
+		}, func(string) bool { return true }},
+	}
+
+	for _, test := range tests {
+		expected, experr := visits(filepath.WalkDir, root, test.fn)
+		got, err := visits(func(root string, fn fs.WalkDirFunc) error {
+			return walkDir(root, fn, test.want)
+		}, root, test.fn)
+
+		if err != experr {
+			t.Errorf("%s: walkDir returned %v; want %v", test.name, err, experr)
+		}
+		if !equalStrings(got, expected) {
+			t.Errorf("%s: walkDir visited\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(expected, "\n"))
+		}
+	}
+}
+
+// TestWalkDir_Errors tests that the errors are reported like with
+// filepath.WalkDir.
+func TestWalkDir_Errors(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("the permissions are not enforced for root")
+	}
+
+	root := newTree(t, dirTree(2, 2))
+	locked := filepath.Join(root, "d0")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	for _, path := range []string{root, filepath.Join(root, "missing")} {
+		none := func(string, fs.DirEntry) error { return nil }
+		expected, _ := visits(filepath.WalkDir, path, none)
+		got, _ := visits(func(root string, fn fs.WalkDirFunc) error {
+			return walkDir(root, fn, func(string) bool { return true })
+		}, path, none)
+
+		if !equalStrings(got, expected) {
+			t.Errorf("walkDir visited\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+		}
+	}
+}
+
+// BenchmarkWalkDir compares walking a large tree reading the directories
+// concurrently and with filepath.WalkDir.
+func BenchmarkWalkDir(b *testing.B) {
+	root := newTree(b, dirTree(6, 4))
+	none := func(string, fs.DirEntry, error) error { return nil }
+
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			walkDir(root, none, func(string) bool { return true })
+		}
+	})
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			filepath.WalkDir(root, none)
+		}
+	})
+}
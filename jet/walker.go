@@ -0,0 +1,618 @@
+/*
+ * jet - Just Edit Text
+ * Copyright (C) 2023 Nicolò Santamaria
+ *
+ * Jet is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Jet is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package jet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type pair struct {
+	pattern     *regexp.Regexp
+	replacement []byte
+	// form is the normalization form of the text matched by the pattern,
+	// the rest of the text keeps its original form.
+	form normForm
+}
+
+func (p pair) match(src []byte) bool {
+	return p.pattern.Match(p.form.normalize(src))
+}
+
+func (p pair) replaceAll(src []byte) []byte {
+	if p.form != noNorm && !isASCII(src) {
+		return joinSegments(p.replaceSegments(p.form.segments(src)), false)
+	}
+	return p.pattern.ReplaceAll(src, p.replacement)
+}
+
+type pairset []pair
+
+func (p pairset) match(src []byte) bool {
+	for _, pair := range p {
+		if pair.match(src) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p pairset) replaceAll(src []byte) []byte {
+	for _, pair := range p {
+		src = pair.replaceAll(src)
+	}
+	return src
+}
+
+type walker struct {
+	Options
+	pairs pairset
+	// filter rejects the files that can't contain any match of the pairs.
+	filter   *prefilter
+	roots    []string
+	followed map[string]bool
+	visited  map[string]bool
+	edited   map[fileKey]bool
+	renames  []rename
+	// jobs are the edits waiting for the renames to be checked and
+	// conflicted reports whether they conflict.
+	jobs       []job
+	conflicted bool
+	refFiles   []string
+	// refNames are the names of the files that would be renamed, which
+	// their references contain, see wouldTouch.
+	refNames []string
+	goIdent  goIdent
+	goFiles  []string
+	// out is the writer of the output, the standard output if nil.
+	out io.Writer
+	// headers enables printing the headers of the files with -p.
+	headers bool
+	// mirror is the set of the files written to OutDir and outRoots are
+	// the directories their paths in OutDir are relative to.
+	mirror   *mirror
+	outRoots []string
+	// root is the directory currently walked, paths are relative to it
+	// when ReplacePaths is set.
+	root string
+	// listed are the paths read from FilesFrom, if already read.
+	listed []string
+	// ctx cancels the run and rec collects its report.
+	ctx context.Context
+	rec *recorder
+	*sync.WaitGroup
+}
+
+func (w *walker) matchGlob(path string) bool {
+	ok, err := filepath.Match(w.Glob, filepath.Base(path))
+	if err != nil {
+		w.fail(err)
+	}
+	return ok
+}
+
+func (w *walker) edit(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	// Leave the large files without any match as they are, without reading
+	// or streaming them.
+	if w.skippable(path, info) {
+		return
+	}
+
+	// The files converted to UTF-8 are read as a whole, in line mode too.
+	if w.StreamThreshold > 0 && info.Size() > w.StreamThreshold && w.streamable() && w.streamsRaw(path) {
+		w.editStream(path)
+		return
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	// The patterns are matched against the content converted to UTF-8.
+	enc, text, err := w.decode(b)
+	if err != nil {
+		w.fail(fmt.Errorf("%s: %w", path, err))
+		return
+	}
+	// The CRLF line endings are normalized so that $ and . behave as with LF.
+	normalize, restore := w.lineEndings(text)
+	if normalize {
+		text = toLF(text)
+	}
+
+	// Leave the files without any match as they are.
+	if !w.filter.match(text) && !w.convertsEOL() && !w.NormalizeOutput {
+		if w.ToStdout && !w.ChangedOnly {
+			w.header(path)
+			w.stdout().Write(b)
+		}
+		return
+	}
+
+	switch {
+	case !w.replaces(path):
+	case w.LineMode:
+		var buf bytes.Buffer
+		w.pairs.replaceLines(&buf, bytes.NewReader(text))
+		text = buf.Bytes()
+	default:
+		text = w.replace(path, text)
+	}
+	if w.NormalizeOutput {
+		text = w.normForm().normalize(text)
+	}
+	if restore {
+		text = toCRLF(text)
+	}
+
+	out, err := enc.encode(text)
+	if err != nil {
+		w.fail(fmt.Errorf("%s: %w", path, err))
+		return
+	}
+	w.save(path, info, out, !bytes.Equal(out, b))
+}
+
+// save prints data to stdout if ToStdout is set, otherwise it writes it to
+// the file at path described by info, or to its copy in OutDir.
+// Nothing is saved if the content is not changed and ChangedOnly is set.
+func (w *walker) save(path string, info fs.FileInfo, data []byte, changed bool) {
+	if w.ChangedOnly && !changed {
+		return
+	}
+	if w.ToStdout {
+		w.header(path)
+		w.stdout().Write(data)
+		return
+	}
+
+	dst := path
+	if w.OutDir != "" {
+		var err error
+		if dst, err = w.outFile(path); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	w.wrote(dst)
+
+	if err := w.writeFile(dst, info, data); err != nil {
+		w.fail(err)
+		return
+	}
+	w.rec.written(path)
+	if w.OutDir != "" {
+		w.mirror.add(path)
+	}
+}
+
+// writeFile atomically writes data to the file at path described by info,
+// preserving its metadata, or creates it with the same permissions in
+// OutDir.
+func (w *walker) writeFile(path string, info fs.FileInfo, data []byte) (err error) {
+	write := func(out io.Writer) error {
+		_, err := out.Write(data)
+		return err
+	}
+	switch {
+	case w.OutDir != "":
+		err = createFile(path, info.Mode().Perm(), write)
+	case linkCount(info) > 1:
+		// Replacing the file would detach it from its other hard links.
+		err = os.WriteFile(path, data, info.Mode().Perm())
+	default:
+		err = replaceFile(path, write)
+	}
+	if err != nil {
+		return err
+	}
+	return w.restoreTimes(path, info)
+}
+
+// restoreTimes sets the modification time of the file at path back to the
+// one in info if KeepMtime is set.
+func (w *walker) restoreTimes(path string, info fs.FileInfo) error {
+	if !w.KeepMtime {
+		return nil
+	}
+	return os.Chtimes(path, time.Now(), info.ModTime())
+}
+
+// editStdin writes to stdout the content read from stdin with all the pairs
+// applied, as soon as it is processed.
+func (w *walker) editStdin() {
+	// The tokens and the cells can only be found in the whole content.
+	// The language of stdin is not known, so it is made only of code.
+	if !w.streamable() {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		normalize, restore := w.lineEndings(b)
+		if normalize {
+			b = toLF(b)
+		}
+		b = w.replace("", b)
+		if w.NormalizeOutput {
+			b = w.normForm().normalize(b)
+		}
+		if restore {
+			b = toCRLF(b)
+		}
+		w.stdout().Write(b)
+		return
+	}
+
+	if err := w.transform(w.stdout(), os.Stdin); err != nil {
+		w.fail(err)
+	}
+}
+
+// editFilename renames path applying the pairs to its base name and returns
+// the new path.
+func (w *walker) editFilename(path string) string {
+	newpath := w.newPath(path)
+
+	// Return early if there are no changes in the name.
+	if newpath == path {
+		return path
+	}
+
+	if err := w.rename(path, newpath); err != nil {
+		w.fail(err)
+		return path
+	}
+	return newpath
+}
+
+func isHidden(name string) bool {
+	return name != "." && name != ".." && strings.HasPrefix(name, ".")
+}
+
+func (w *walker) processFile(path string, d fs.DirEntry, err error) error {
+	if w.done() {
+		return fs.SkipAll
+	}
+	if err != nil {
+		w.fail(err)
+		return nil
+	}
+
+	// If the depth exceeds skip the entire directory.
+	if d.IsDir() && w.MaxDepth >= 0 && depth(path) > w.MaxDepth {
+		return fs.SkipDir
+	}
+	// Skip hidden files if not specified otherwise.
+	if isHidden(d.Name()) && !w.IncludeHidden {
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	// Skip the paths already processed, e.g. when the given paths overlap.
+	if !w.visit(path) {
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	if isSymlink(d) && w.FollowSymlinks {
+		w.walkLink(path)
+	}
+
+	var renamed, edited bool
+	if w.matchGlob(path) {
+		// The renames are performed at the end of the walk, so that the
+		// paths don't change while walking and editing the files.
+		// Symbolic links are renamed themselves, not their targets.
+		if w.renaming() && !(w.ReplacePaths && d.IsDir()) {
+			renamed = w.planRename(path)
+		}
+
+		if !d.IsDir() && w.editable(path, d) && w.claim(path) {
+			if w.UpdateRefs {
+				w.refFiles = append(w.refFiles, path)
+			}
+			if w.goIdent.isSet() && filepath.Ext(path) == ".go" {
+				w.goFiles = append(w.goFiles, path)
+			}
+			if !w.NamesOnly && w.rewrites(path) {
+				// The output of the file is written in the walk order.
+				var (
+					out    = w.reserve()
+					fw     = w.withOutput(out)
+					copies = w.copies(renamed)
+				)
+				w.start(job{out: out, fn: func() {
+					if fw.done() {
+						return
+					}
+					fw.edit(path)
+					// The files renamed or not written are copied as they are.
+					if copies {
+						fw.copyOut(path)
+					}
+				}})
+				edited = true
+			}
+		}
+	}
+
+	if !d.IsDir() && !edited && w.copies(renamed) {
+		var (
+			out = w.reserve()
+			fw  = w.withOutput(out)
+		)
+		w.start(job{out: out, fn: func() {
+			fw.copyOut(path)
+		}})
+	}
+
+	return nil
+}
+
+// job is the edit of a file, writing its output to out.
+type job struct {
+	out *slot
+	fn  func()
+}
+
+// start runs j concurrently with the other edits. When the files are
+// renamed, j is deferred until the renames are checked by startJobs.
+func (w *walker) start(j job) {
+	if w.renaming() {
+		w.jobs = append(w.jobs, j)
+		return
+	}
+	w.run(j)
+}
+
+func (w *walker) run(j job) {
+	w.Add(1)
+	go func() {
+		defer w.Done()
+		defer j.out.release()
+		j.fn()
+	}()
+}
+
+// startJobs starts the deferred edits if the planned renames don't conflict,
+// otherwise it reports the conflicts and drops the edits, so that no file is
+// written.
+func (w *walker) startJobs() {
+	jobs := w.jobs
+	w.jobs = nil
+
+	if conflicts := checkRenames(w.renames); len(conflicts) > 0 {
+		for _, c := range conflicts {
+			w.fail(errors.New(c))
+		}
+		for _, j := range jobs {
+			j.out.release()
+		}
+		w.renames = nil
+		w.conflicted = true
+		return
+	}
+	for _, j := range jobs {
+		w.run(j)
+	}
+}
+
+// visit reports whether path is visited for the first time.
+func (w *walker) visit(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+
+	if w.visited == nil {
+		w.visited = make(map[string]bool)
+	}
+	if w.visited[abs] {
+		return false
+	}
+	w.visited[abs] = true
+	return true
+}
+
+// claim reports whether the content of the file at path has not been claimed
+// for editing yet through any other path, including hard and symbolic links.
+func (w *walker) claim(path string) bool {
+	// Every path is a distinct file in OutDir.
+	if w.OutDir != "" {
+		return true
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+
+	if w.edited == nil {
+		w.edited = make(map[fileKey]bool)
+	}
+	key := fileID(path, info)
+	if w.edited[key] {
+		return false
+	}
+	w.edited[key] = true
+	return true
+}
+
+func (w *walker) Walk(paths ...string) {
+	// The values at KeyPath and the CSV cells are matched unescaped, while
+	// the prefilter searches the raw text.
+	if w.KeyPath == "" && len(w.CSVColumns) == 0 {
+		w.filter = newPrefilter(w.pairs)
+	}
+	w.headers = w.ToStdout && !w.NoHeader && (w.Header != "" || w.manyFiles(paths))
+	// Never edit the files already written to OutDir.
+	if w.OutDir != "" {
+		w.visit(w.OutDir)
+		w.mirror = newMirror()
+		for _, p := range paths {
+			w.outRoots = append(w.outRoots, walkRoot(p))
+		}
+	}
+	if w.WithinRoots {
+		w.setRoots(paths...)
+	}
+
+	if w.FilesFrom != "" {
+		w.walkFilesFrom()
+	}
+
+	for _, p := range paths {
+		w.root = walkRoot(p)
+
+		switch {
+		case p == "-":
+			w.editStdin()
+		case w.Git:
+			w.walkGit(p)
+		default:
+			if err := walkDir(p, w.processFile, w.descends); err != nil {
+				w.fail(err)
+			}
+		}
+	}
+
+	// Nothing is edited until the renames are known not to conflict.
+	w.startJobs()
+	w.Wait()
+	// Nothing is renamed if the run has been cancelled.
+	if w.done() || w.conflicted {
+		return
+	}
+	if w.goIdent.isSet() {
+		w.renameGoIdent()
+	}
+	w.applyRenames()
+}
+
+// walkFilesFrom processes the paths listed in the file specified in FilesFrom.
+func (w *walker) walkFilesFrom() {
+	if w.listed == nil {
+		list, err := w.readFilesFrom()
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		w.listed = list
+	}
+
+	// The listed paths are not relative to any walked directory.
+	w.root = ""
+	for _, p := range w.listed {
+		w.processPath(p)
+	}
+}
+
+// readFilesFrom reads the paths listed in the file specified in FilesFrom.
+func (w *walker) readFilesFrom() ([]string, error) {
+	var (
+		r   = os.Stdin
+		sep = byte('\n')
+	)
+
+	if w.FilesFrom != "-" {
+		f, err := os.Open(w.FilesFrom)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if w.NulSeparated {
+		sep = 0
+	}
+	return readList(r, sep)
+}
+
+// readList returns the paths read from r and separated by sep.
+func readList(r io.Reader, sep byte) ([]string, error) {
+	var (
+		br    = bufio.NewReader(r)
+		paths = []string{}
+	)
+
+	for {
+		entry, err := br.ReadString(sep)
+		path := strings.TrimSuffix(entry, string(sep))
+		if sep == '\n' {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path != "" {
+			paths = append(paths, filepath.Clean(path))
+		}
+
+		if err == io.EOF {
+			return paths, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// processPath is like processFile but for a single path outside of a walk.
+func (w *walker) processPath(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	w.processFile(path, fs.FileInfoToDirEntry(info), nil)
+}
+
+func depth(path string) int {
+	return strings.Count(path, string(os.PathSeparator)) + 1
+}
+
+func containsDash(files []string) bool {
+	for _, f := range files {
+		if f == "-" {
+			return true
+		}
+	}
+	return false
+}
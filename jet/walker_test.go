@@ -0,0 +1,1052 @@
+package jet
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPairMatch tests the match function of the pair struct.
+func TestPairMatch(t *testing.T) {
+	p := pair{
+		pattern:     regexp.MustCompile("foo"),
+		replacement: []byte("bar"),
+	}
+
+	tests := []struct {
+		input    []byte
+		expected bool
+	}{
+		{[]byte("foobar"), true},
+		{[]byte("baz"), false},
+		{[]byte("foo baz"), true},
+		{[]byte(""), false},
+	}
+
+	for _, test := range tests {
+		result := p.match(test.input)
+		if result != test.expected {
+			t.Errorf("pair.match(%q) = %v; want %v", test.input, result, test.expected)
+		}
+	}
+}
+
+// TestPairReplaceAll tests the replaceAll function of the pair struct.
+func TestPairReplaceAll(t *testing.T) {
+	p := pair{
+		pattern:     regexp.MustCompile("foo"),
+		replacement: []byte("bar"),
+	}
+
+	tests := []struct {
+		input    []byte
+		expected []byte
+	}{
+		{[]byte("foo foo baz"), []byte("bar bar baz")},
+		{[]byte("no match here"), []byte("no match here")},
+		{[]byte("foofoo"), []byte("barbar")},
+	}
+
+	for _, test := range tests {
+		result := p.replaceAll(test.input)
+		if !bytes.Equal(result, test.expected) {
+			t.Errorf("pair.replaceAll(%q) = %q; want %q", test.input, result, test.expected)
+		}
+	}
+}
+
+// TestPairsetMatch tests the match function of the pairset type.
+func TestPairsetMatch(t *testing.T) {
+	ps := pairset{
+		{
+			pattern:     regexp.MustCompile("foo"),
+			replacement: []byte("bar"),
+		},
+		{
+			pattern:     regexp.MustCompile("baz"),
+			replacement: []byte("qux"),
+		},
+	}
+
+	tests := []struct {
+		input    []byte
+		expected bool
+	}{
+		{[]byte("hello foo"), true},
+		{[]byte("hello baz"), true},
+		{[]byte("hello world"), false},
+	}
+
+	for _, test := range tests {
+		result := ps.match(test.input)
+		if result != test.expected {
+			t.Errorf("pairset.match(%q) = %v; want %v", test.input, result, test.expected)
+		}
+	}
+}
+
+// TestPairsetReplaceAll tests the replaceAll function of the pairset type.
+func TestPairsetReplaceAll(t *testing.T) {
+	ps := pairset{
+		{
+			pattern:     regexp.MustCompile("foo"),
+			replacement: []byte("bar"),
+		},
+		{
+			pattern:     regexp.MustCompile("baz"),
+			replacement: []byte("qux"),
+		},
+	}
+
+	tests := []struct {
+		input    []byte
+		expected []byte
+	}{
+		{[]byte("foo baz foo baz"), []byte("bar qux bar qux")},
+		{[]byte("no match here"), []byte("no match here")},
+		{[]byte("foo and baz"), []byte("bar and qux")},
+	}
+
+	for _, test := range tests {
+		result := ps.replaceAll(test.input)
+		if !bytes.Equal(result, test.expected) {
+			t.Errorf("pairset.replaceAll(%q) = %q; want %q", test.input, result, test.expected)
+		}
+	}
+}
+
+// TestWalkerEdit tests the edit function of the walker struct with a temporary file.
+func TestWalkerEdit(t *testing.T) {
+	// Create a temporary file with initial content.
+	tmpfile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	content := []byte("foo baz foo baz")
+	if _, err := tmpfile.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Initialize the walker.
+	w := &walker{
+		Options: Options{
+			ToStdout:      false,
+			Glob:          "*",
+			IsVerbose:     false,
+			MaxDepth:      -1,
+			IncludeHidden: true,
+			ReplaceNames:  false,
+			NamesOnly:     false,
+		},
+		pairs: pairset{
+			{
+				pattern:     regexp.MustCompile("foo"),
+				replacement: []byte("bar"),
+			},
+			{
+				pattern:     regexp.MustCompile("baz"),
+				replacement: []byte("qux"),
+			},
+		},
+		WaitGroup: new(sync.WaitGroup),
+	}
+
+	// Perform the edit operation.
+	w.edit(tmpfile.Name())
+
+	// Read back the content of the file.
+	newContent, err := os.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("bar qux bar qux")
+	if !bytes.Equal(newContent, expected) {
+		t.Errorf("After edit, content = %q; want %q", newContent, expected)
+	}
+}
+
+// TestWalkerEditFilename tests the editFilename function of the walker struct.
+func TestWalkerEditFilename(t *testing.T) {
+	// Create a temporary directory.
+	tmpDir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Create a file with a name that needs to be replaced.
+	oldPath := filepath.Join(tmpDir, "foo.txt")
+	newPath := filepath.Join(tmpDir, "bar.txt")
+	if _, err := os.Create(oldPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Initialize the walker.
+	w := &walker{
+		Options: Options{
+			ToStdout:      false,
+			Glob:          "*",
+			IsVerbose:     false,
+			MaxDepth:      -1,
+			IncludeHidden: true,
+			ReplaceNames:  true,
+			NamesOnly:     true,
+		},
+		pairs: pairset{
+			{
+				pattern:     regexp.MustCompile("foo"),
+				replacement: []byte("bar"),
+			},
+		},
+	}
+
+	// Perform the editFilename operation.
+	resultPath := w.editFilename(oldPath)
+
+	// Check if the file has been renamed.
+	if resultPath != newPath {
+		t.Errorf("editFilename result = %q; want %q", resultPath, newPath)
+	}
+	if _, err := os.Stat(newPath); os.IsNotExist(err) {
+		t.Errorf("Renamed file %q does not exist", newPath)
+	}
+}
+
+func TestWalkerEditStdin(t *testing.T) {
+	// Prepare the walker with replacement pairs
+	w := &walker{
+		Options: Options{
+			ToStdout:      true,
+			Glob:          "*",
+			IsVerbose:     false,
+			MaxDepth:      -1,
+			IncludeHidden: true,
+			ReplaceNames:  false,
+			NamesOnly:     false,
+		},
+		pairs: pairset{
+			{
+				pattern:     regexp.MustCompile("foo"),
+				replacement: []byte("bar"),
+			},
+			{
+				pattern:     regexp.MustCompile("baz"),
+				replacement: []byte("qux"),
+			},
+		},
+		WaitGroup: new(sync.WaitGroup),
+	}
+
+	// Save original stdin and stdout
+	origStdin := os.Stdin
+	origStdout := os.Stdout
+	defer func() {
+		os.Stdin = origStdin
+		os.Stdout = origStdout
+	}()
+
+	// Create a pipe to simulate stdin
+	rStdin, wStdin, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe for stdin: %v", err)
+	}
+	os.Stdin = rStdin
+
+	// Create a pipe to capture stdout
+	rStdout, wStdout, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe for stdout: %v", err)
+	}
+	os.Stdout = wStdout
+
+	// Write input to stdin (include a null byte to mark the end)
+	input := []byte("foo baz foo\x00")
+	if _, err := wStdin.Write(input); err != nil {
+		t.Fatalf("failed to write to stdin: %v", err)
+	}
+	wStdin.Close() // End of input
+
+	// Run editStdin which reads from os.Stdin and writes to os.Stdout
+	w.editStdin()
+
+	// Close stdout writer to allow reading
+	wStdout.Close()
+
+	// Read output
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, rStdout); err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+
+	output := buf.String()
+	expected := "bar qux bar\x00"
+
+	if output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+// TestWalkerEditInvalidPath tests the edit function of the walker struct with a non-existent file.
+func TestWalkerEditInvalidPath(t *testing.T) {
+	// Capture stdout to check error message.
+	origStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	wk := &walker{
+		Options: Options{
+			ToStdout:      false,
+			Glob:          "*",
+			IsVerbose:     false,
+			MaxDepth:      -1,
+			IncludeHidden: true,
+			ReplaceNames:  false,
+			NamesOnly:     false,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+		WaitGroup: new(sync.WaitGroup),
+	}
+
+	wk.edit("non_existent_file.txt")
+
+	w.Close()
+	os.Stdout = origStdout
+
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	r.Close()
+
+	output := buf.String()
+	if !strings.Contains(output, "no such file or directory") {
+		t.Errorf("expected error about missing file, got: %s", output)
+	}
+}
+
+// TestDepth tests the depth function.
+func TestDepth(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"", 1},
+		{"a/b/c", 3},
+		{"/a/b/c", 4},
+	}
+
+	for _, test := range tests {
+		result := depth(test.path)
+		if result != test.expected {
+			t.Errorf("depth(%q) = %d; want %d", test.path, result, test.expected)
+		}
+	}
+}
+
+// TestIsHidden tests the isHidden function.
+func TestIsHidden(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{".hidden", true},
+		{"..", false},
+		{".", false},
+		{"visible", false},
+	}
+
+	for _, test := range tests {
+		result := isHidden(test.name)
+		if result != test.expected {
+			t.Errorf("isHidden(%q) = %v; want %v", test.name, result, test.expected)
+		}
+	}
+}
+
+// TestProcessFile tests the processFile function of the walker struct.
+func TestProcessFile(t *testing.T) {
+	// Create a temporary directory with files.
+	tmpDir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Create files and directories.
+	files := []string{
+		"foo.txt",
+		".hidden.txt",
+		"subdir/bar.txt",
+		"subdir/.hidden_sub.txt",
+	}
+	for _, file := range files {
+		fullPath := filepath.Join(tmpDir, file)
+		if strings.Contains(file, "/") {
+			os.MkdirAll(filepath.Dir(fullPath), 0755)
+		}
+		if _, err := os.Create(fullPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Initialize the walker.
+	w := &walker{
+		Options: Options{
+			ToStdout:      false,
+			Glob:          "*.txt",
+			IsVerbose:     false,
+			MaxDepth:      -1,
+			IncludeHidden: false,
+			ReplaceNames:  false,
+			NamesOnly:     false,
+		},
+		pairs: pairset{
+			{
+				pattern:     regexp.MustCompile("foo"),
+				replacement: []byte("bar"),
+			},
+		},
+		WaitGroup: new(sync.WaitGroup),
+	}
+
+	// Walk the directory.
+	w.Walk(tmpDir)
+
+	// Wait for all goroutines to finish.
+	w.Wait()
+
+	// Check that the non-hidden file has been edited.
+	content, err := os.ReadFile(filepath.Join(tmpDir, "foo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{}
+	if !bytes.Equal(content, expected) {
+		t.Errorf("Expected content of 'foo.txt' to be empty; got %q", content)
+	}
+
+	// Check that the hidden file has not been edited.
+	content, err = os.ReadFile(filepath.Join(tmpDir, ".hidden.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, expected) {
+		t.Errorf("Expected content of '.hidden.txt' to be empty; got %q", content)
+	}
+}
+
+// TestRuleSetString tests the String method of the RuleSet type.
+func TestRuleSetString(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    RuleSet
+		expected string
+	}{
+		{
+			name: "Single Rule",
+			rules: RuleSet{
+				{
+					Pattern:     regexp.MustCompile("foo"),
+					Replacement: "bar",
+				},
+			},
+			expected: "['foo', 'bar']",
+		},
+		{
+			name: "Multiple Rules",
+			rules: RuleSet{
+				{
+					Pattern:     regexp.MustCompile("foo"),
+					Replacement: "bar",
+				},
+				{
+					Pattern:     regexp.MustCompile("baz"),
+					Replacement: "qux",
+				},
+			},
+			expected: "['foo', 'bar'] ['baz', 'qux']",
+		},
+		{
+			name:     "Empty RuleSet",
+			rules:    RuleSet{},
+			expected: "",
+		},
+		{
+			name: "Special Characters",
+			rules: RuleSet{
+				{
+					Pattern:     regexp.MustCompile(`a\w+b`),
+					Replacement: "replacement",
+				},
+			},
+			expected: "['a\\w+b', 'replacement']",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := test.rules.String()
+			if result != test.expected {
+				t.Errorf("RuleSet.String() = %q; want %q", result, test.expected)
+			}
+		})
+	}
+}
+
+// Utility to capture stdout.
+func captureStdout(f func()) string {
+	origStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	f()
+	w.Close()
+	os.Stdout = origStdout
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	r.Close()
+	return buf.String()
+}
+
+// MockDirEntry for testing processFile.
+type MockDirEntry struct {
+	name  string
+	isDir bool
+}
+
+func (m MockDirEntry) Name() string               { return m.name }
+func (m MockDirEntry) IsDir() bool                { return m.isDir }
+func (m MockDirEntry) Type() fs.FileMode          { return 0 }
+func (m MockDirEntry) Info() (fs.FileInfo, error) { return nil, nil }
+
+func TestWalkerEdit_ErrorReadingFile(t *testing.T) {
+	w := &walker{
+		pairs: pairset{{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")}},
+	}
+
+	// Try editing a non-existent file.
+	output := captureStdout(func() {
+		w.edit("non_existent_file.txt")
+	})
+	if !strings.Contains(output, "no such file or directory") {
+		t.Errorf("expected error message about missing file, got: %s", output)
+	}
+}
+
+func TestWalkerEdit_ToStdout(t *testing.T) {
+	// Create a temp file
+	tmpfile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	content := []byte("foo baz")
+	tmpfile.Write(content)
+	tmpfile.Close()
+
+	w := &walker{
+		Options: Options{
+			ToStdout: true,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		w.edit(tmpfile.Name())
+	})
+
+	if output != "bar baz" {
+		t.Errorf("expected replaced output 'bar baz', got %q", output)
+	}
+}
+
+func TestWalkerEdit_Verbose(t *testing.T) {
+	// Create a temp file
+	tmpfile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	tmpfile.Write([]byte("foo"))
+	tmpfile.Close()
+
+	w := &walker{
+		Options: Options{
+			IsVerbose: true,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		w.edit(tmpfile.Name())
+	})
+	if !strings.Contains(output, "writing") {
+		t.Errorf("expected 'writing' in verbose output, got %q", output)
+	}
+}
+
+func TestWalkerEdit_StatError(t *testing.T) {
+	// Stat error can be simulated by removing file before stat.
+	tmpfile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := tmpfile.Name()
+	tmpfile.Write([]byte("foo"))
+	tmpfile.Close()
+	os.Remove(name) // remove the file to cause a stat error later
+
+	w := &walker{
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		w.edit(name)
+	})
+	if !strings.Contains(output, "no such file or directory") {
+		t.Errorf("expected stat error, got %q", output)
+	}
+}
+
+func TestWalkerEdit_WriteError(t *testing.T) {
+	// Cause write error by using a directory instead of a file
+	tmpdir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	w := &walker{
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		// Attempting to write to a directory path should fail
+		w.edit(tmpdir)
+	})
+	if !strings.Contains(output, "is a directory") {
+		t.Errorf("expected write error for directory, got %q", output)
+	}
+}
+
+// Tests for editFilename
+func TestWalkerEditFilename_NoChange(t *testing.T) {
+	w := &walker{
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	// If filename does not contain "foo" no change is made.
+	oldPath := "somefile.txt"
+	newPath := w.editFilename(oldPath)
+	if newPath != oldPath {
+		t.Errorf("expected no change, got %q", newPath)
+	}
+}
+
+func TestWalkerEditFilename_ChangeAndRenameSuccess(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldPath := filepath.Join(tmpdir, "foo.txt")
+	if _, err := os.Create(oldPath); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &walker{
+		Options: Options{
+			IsVerbose: true,
+		},
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		newPath := w.editFilename(oldPath)
+		expected := filepath.Join(tmpdir, "bar.txt")
+		if newPath != expected {
+			t.Errorf("expected newPath %q, got %q", expected, newPath)
+		}
+	})
+
+	if !strings.Contains(output, "renaming") {
+		t.Errorf("expected 'renaming' in verbose output")
+	}
+}
+
+func TestWalkerEditFilename_RenameError(t *testing.T) {
+	// Attempt rename to a non-writable directory to force error
+	tmpdir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldPath := filepath.Join(tmpdir, "foo.txt")
+	if _, err := os.Create(oldPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the directory read-only
+	os.Chmod(tmpdir, 0500)
+	defer os.Chmod(tmpdir, 0700) // restore permissions
+
+	w := &walker{
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	output := captureStdout(func() {
+		newPath := w.editFilename(oldPath)
+		if newPath != oldPath {
+			t.Errorf("expected to return oldPath on error, got %q", newPath)
+		}
+	})
+
+	if !strings.Contains(output, "permission denied") {
+		t.Errorf("expected rename error message, got %q", output)
+	}
+}
+
+// Tests for processFile
+func TestWalkerProcessFile_WithErrorParameter(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			Glob:          "*",
+			MaxDepth:      -1,
+			IncludeHidden: true,
+		},
+		WaitGroup: new(sync.WaitGroup),
+	}
+
+	output := captureStdout(func() {
+		w.processFile("anyfile.txt", MockDirEntry{name: "anyfile.txt", isDir: false},
+			fs.ErrNotExist) // Simulated error
+	})
+	if !strings.Contains(output, "file does not exist") && !strings.Contains(output, "no such file") {
+		t.Errorf("expected error message, got %q", output)
+	}
+}
+
+func TestWalkerProcessFile_ExceedMaxDepth(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			MaxDepth: 1,
+		},
+		WaitGroup: new(sync.WaitGroup),
+	}
+
+	// depth("a/b/c") = 3, which is greater than MaxDepth=1
+	err := w.processFile("a/b/c", MockDirEntry{name: "c", isDir: true}, nil)
+	if err != fs.SkipDir {
+		t.Errorf("expected fs.SkipDir for exceeding max depth, got %v", err)
+	}
+}
+
+func TestWalkerProcessFile_HiddenFilesNotIncluded(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			IncludeHidden: false,
+			MaxDepth:      -1,
+		},
+		WaitGroup: new(sync.WaitGroup),
+	}
+
+	// Hidden file
+	err := w.processFile(".hiddenfile", MockDirEntry{name: ".hiddenfile", isDir: false}, nil)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	// Since it's hidden and not included, no action is taken.
+	// To confirm no goroutine started, we can rely on coverage or manual checks.
+}
+
+func TestWalkerProcessFile_HiddenDirsNotIncluded(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			IncludeHidden: false,
+		},
+		WaitGroup: new(sync.WaitGroup),
+	}
+
+	err := w.processFile(".hiddendir", MockDirEntry{name: ".hiddendir", isDir: true}, nil)
+	if err != fs.SkipDir {
+		t.Errorf("expected fs.SkipDir for hidden directory, got %v", err)
+	}
+}
+
+func TestWalkerProcessFile_MatchesGlobAndNamesOnly(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			Glob:      "*.txt",
+			NamesOnly: true,
+		},
+		WaitGroup: new(sync.WaitGroup),
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	tmpdir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	oldFile := filepath.Join(tmpdir, "foo.txt")
+	os.Create(oldFile)
+
+	// processFile should trigger a rename in a goroutine
+	output := captureStdout(func() {
+		err := w.processFile(oldFile, MockDirEntry{name: "foo.txt", isDir: false}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Wait for goroutine and perform the planned renames
+		w.Wait()
+		w.applyRenames()
+	})
+
+	newFile := filepath.Join(tmpdir, "bar.txt")
+	if _, err := os.Stat(newFile); os.IsNotExist(err) {
+		t.Errorf("expected renamed file 'bar.txt' to exist")
+	}
+
+	// NamesOnly means no content edit, just rename. Check output if verbose not set - no direct output expected.
+	if output != "" {
+		// Might print errors if any. It's okay if it's empty.
+	}
+}
+
+func TestWalkerProcessFile_MatchesGlobAndEditFile(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			Glob:      "*.txt",
+			NamesOnly: false,
+		},
+		WaitGroup: new(sync.WaitGroup),
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	// Create a temp file that matches glob and contains "foo".
+	tmpdir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	filePath := filepath.Join(tmpdir, "test.txt")
+	os.WriteFile(filePath, []byte("foo"), 0644)
+
+	output := captureStdout(func() {
+		err := w.processFile(filePath, MockDirEntry{name: "test.txt", isDir: false}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Wait()
+	})
+
+	// Check file content edited
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "bar" {
+		t.Errorf("expected content 'bar', got %q", content)
+	}
+
+	// output might be empty or contain error messages if something went wrong
+	if strings.Contains(output, "error") {
+		t.Errorf("unexpected error output: %s", output)
+	}
+}
+
+func TestWalkerProcessFile_NoMatchGlob(t *testing.T) {
+	w := &walker{
+		Options: Options{
+			Glob: "*.md",
+		},
+		WaitGroup: new(sync.WaitGroup),
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+		},
+	}
+
+	err := w.processFile("test.txt", MockDirEntry{name: "test.txt"}, nil)
+	if err != nil {
+		t.Errorf("expected no error if glob does not match, got %v", err)
+	}
+	// No edits, no renames, no actions taken.
+}
+
+// TestWalkerWalkFilesFrom tests that the listed paths are edited without
+// descending into the listed directories.
+func TestWalkerWalkFilesFrom(t *testing.T) {
+	files := map[string]string{
+		"a.txt":        "foo",
+		"b c.txt":      "foo",
+		"sub/d.txt":    "foo",
+		"unlisted.txt": "foo",
+	}
+	tmpdir := newTree(t, files)
+
+	var (
+		a   = filepath.Join(tmpdir, "a.txt")
+		b   = filepath.Join(tmpdir, "b c.txt")
+		sub = filepath.Join(tmpdir, "sub")
+	)
+
+	tests := []struct {
+		sep  byte
+		list string
+	}{
+		{'\n', a + "\r\n" + b + "\n\n" + sub + "\n"},
+		{0, a + "\x00" + b + "\x00" + sub + "\x00"},
+	}
+
+	for _, test := range tests {
+		for name := range files {
+			os.WriteFile(filepath.Join(tmpdir, name), []byte("foo"), 0644)
+		}
+
+		w := &walker{
+			Options: Options{
+				Glob:     "*",
+				MaxDepth: -1,
+			},
+			WaitGroup: new(sync.WaitGroup),
+			pairs: pairset{
+				{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+			},
+		}
+
+		list, err := readList(strings.NewReader(test.list), test.sep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.listed = list
+		w.walkFilesFrom()
+		w.Wait()
+
+		expected := map[string]string{
+			"a.txt":        "bar",
+			"b c.txt":      "bar",
+			"sub/d.txt":    "foo",
+			"unlisted.txt": "foo",
+		}
+		for name, want := range expected {
+			content, err := os.ReadFile(filepath.Join(tmpdir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != want {
+				t.Errorf("separator %q: expected %s to contain %q, got %q", test.sep, name, want, content)
+			}
+		}
+	}
+}
+
+// TestWalkerEdit_KeepMtime tests that the modification time is preserved
+// both when editing in memory and when streaming.
+func TestWalkerEdit_KeepMtime(t *testing.T) {
+	mtime := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, threshold := range []int64{0, 1} {
+		path := filepath.Join(t.TempDir(), "file.txt")
+		if err := os.WriteFile(path, []byte("foo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		w := &walker{
+			Options: Options{
+				KeepMtime:       true,
+				StreamThreshold: threshold,
+			},
+			pairs: pairset{
+				{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
+			},
+		}
+		w.edit(path)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("threshold %d: expected mtime %v, got %v", threshold, mtime, info.ModTime())
+		}
+		if content, _ := os.ReadFile(path); string(content) != "bar" {
+			t.Errorf("threshold %d: expected content 'bar', got %q", threshold, content)
+		}
+	}
+}
+
+// TestWalkerWalk_Deduplicate tests that overlapping paths and hard links
+// are edited exactly once.
+func TestWalkerWalk_Deduplicate(t *testing.T) {
+	tmpdir := newTree(t, map[string]string{"src/a.txt": "foo", "src/pkg/b.txt": "foo"})
+	src := filepath.Join(tmpdir, "src")
+	pkg := filepath.Join(src, "pkg")
+	files := []string{
+		filepath.Join(src, "a.txt"),
+		filepath.Join(pkg, "b.txt"),
+	}
+	if err := os.Link(files[0], filepath.Join(pkg, "hardlink.txt")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	w := &walker{
+		Options: Options{
+			Glob:     "*",
+			MaxDepth: -1,
+		},
+		WaitGroup: new(sync.WaitGroup),
+		pairs: pairset{
+			{pattern: regexp.MustCompile("foo"), replacement: []byte("${0}o")},
+		},
+	}
+	w.Walk(pkg, src, src, files[1])
+
+	for _, f := range append(files, filepath.Join(pkg, "hardlink.txt")) {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "fooo" {
+			t.Errorf("expected %s to be edited once, got %q", f, content)
+		}
+	}
+}
@@ -2,129 +2,21 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
-	"regexp"
-	"strings"
-	"sync"
 	"testing"
-)
-
-// TestPairMatch tests the match function of the pair struct.
-func TestPairMatch(t *testing.T) {
-	p := pair{
-		pattern:     regexp.MustCompile("foo"),
-		replacement: []byte("bar"),
-	}
-
-	tests := []struct {
-		input    []byte
-		expected bool
-	}{
-		{[]byte("foobar"), true},
-		{[]byte("baz"), false},
-		{[]byte("foo baz"), true},
-		{[]byte(""), false},
-	}
 
-	for _, test := range tests {
-		result := p.match(test.input)
-		if result != test.expected {
-			t.Errorf("pair.match(%q) = %v; want %v", test.input, result, test.expected)
-		}
-	}
-}
-
-// TestPairReplaceAll tests the replaceAll function of the pair struct.
-func TestPairReplaceAll(t *testing.T) {
-	p := pair{
-		pattern:     regexp.MustCompile("foo"),
-		replacement: []byte("bar"),
-	}
-
-	tests := []struct {
-		input    []byte
-		expected []byte
-	}{
-		{[]byte("foo foo baz"), []byte("bar bar baz")},
-		{[]byte("no match here"), []byte("no match here")},
-		{[]byte("foofoo"), []byte("barbar")},
-	}
-
-	for _, test := range tests {
-		result := p.replaceAll(test.input)
-		if !bytes.Equal(result, test.expected) {
-			t.Errorf("pair.replaceAll(%q) = %q; want %q", test.input, result, test.expected)
-		}
-	}
-}
-
-// TestPairsetMatch tests the match function of the pairset type.
-func TestPairsetMatch(t *testing.T) {
-	ps := pairset{
-		{
-			pattern:     regexp.MustCompile("foo"),
-			replacement: []byte("bar"),
-		},
-		{
-			pattern:     regexp.MustCompile("baz"),
-			replacement: []byte("qux"),
-		},
-	}
-
-	tests := []struct {
-		input    []byte
-		expected bool
-	}{
-		{[]byte("hello foo"), true},
-		{[]byte("hello baz"), true},
-		{[]byte("hello world"), false},
-	}
-
-	for _, test := range tests {
-		result := ps.match(test.input)
-		if result != test.expected {
-			t.Errorf("pairset.match(%q) = %v; want %v", test.input, result, test.expected)
-		}
-	}
-}
-
-// TestPairsetReplaceAll tests the replaceAll function of the pairset type.
-func TestPairsetReplaceAll(t *testing.T) {
-	ps := pairset{
-		{
-			pattern:     regexp.MustCompile("foo"),
-			replacement: []byte("bar"),
-		},
-		{
-			pattern:     regexp.MustCompile("baz"),
-			replacement: []byte("qux"),
-		},
-	}
-
-	tests := []struct {
-		input    []byte
-		expected []byte
-	}{
-		{[]byte("foo baz foo baz"), []byte("bar qux bar qux")},
-		{[]byte("no match here"), []byte("no match here")},
-		{[]byte("foo and baz"), []byte("bar and qux")},
-	}
-
-	for _, test := range tests {
-		result := ps.replaceAll(test.input)
-		if !bytes.Equal(result, test.expected) {
-			t.Errorf("pairset.replaceAll(%q) = %q; want %q", test.input, result, test.expected)
-		}
-	}
-}
+	"github.com/NicoNex/jet/jet"
+)
 
-func TestPairsetSetValidPattern(t *testing.T) {
-	var ps pairset
+// TestRuleListSetValidPattern tests the Set method of ruleList for valid patterns.
+func TestRuleListSetValidPattern(t *testing.T) {
+	var rl ruleList
 	// Reset the flag CommandLine so it doesn't interfere with the test
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
@@ -133,405 +25,31 @@ func TestPairsetSetValidPattern(t *testing.T) {
 	os.Args = []string{"cmd", "replacement"}
 	flag.Parse()
 
-	if err := ps.Set("foo"); err != nil {
+	if err := rl.Set("foo"); err != nil {
 		t.Errorf("expected no error for valid pattern, got %v", err)
 	}
 
-	if len(ps) != 1 {
-		t.Fatalf("expected 1 pair, got %d", len(ps))
+	if len(rl) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(rl))
 	}
-	if ps[0].pattern.String() != "foo" {
-		t.Errorf("expected pattern to be 'foo', got %q", ps[0].pattern.String())
+	if rl[0].Pattern.String() != "foo" {
+		t.Errorf("expected pattern to be 'foo', got %q", rl[0].Pattern.String())
 	}
-	if string(ps[0].replacement) != "replacement" {
-		t.Errorf("expected replacement to be 'replacement', got %q", ps[0].replacement)
+	if rl[0].Replacement != "replacement" {
+		t.Errorf("expected replacement to be 'replacement', got %q", rl[0].Replacement)
 	}
 }
 
-// TestPairsetSetInvalidPattern tests the Set method of pairset for invalid patterns.
-func TestPairsetSetInvalidPattern(t *testing.T) {
-	var ps pairset
+// TestRuleListSetInvalidPattern tests the Set method of ruleList for invalid patterns.
+func TestRuleListSetInvalidPattern(t *testing.T) {
+	var rl ruleList
 	// The pattern "(?" is invalid
-	err := ps.Set("(?")
+	err := rl.Set("(?")
 	if err == nil {
 		t.Errorf("expected error for invalid pattern, got nil")
 	}
 }
 
-// TestWalkerEdit tests the edit function of the walker struct with a temporary file.
-func TestWalkerEdit(t *testing.T) {
-	// Create a temporary file with initial content.
-	tmpfile, err := os.CreateTemp("", "testfile")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.Remove(tmpfile.Name())
-
-	content := []byte("foo baz foo baz")
-	if _, err := tmpfile.Write(content); err != nil {
-		t.Fatal(err)
-	}
-	if err := tmpfile.Close(); err != nil {
-		t.Fatal(err)
-	}
-
-	// Initialize the walker.
-	w := &walker{
-		ToStdout:      false,
-		Glob:          "*",
-		IsVerbose:     false,
-		MaxDepth:      -1,
-		IncludeHidden: true,
-		ReplaceNames:  false,
-		NamesOnly:     false,
-		pairs: pairset{
-			{
-				pattern:     regexp.MustCompile("foo"),
-				replacement: []byte("bar"),
-			},
-			{
-				pattern:     regexp.MustCompile("baz"),
-				replacement: []byte("qux"),
-			},
-		},
-		WaitGroup: new(sync.WaitGroup),
-	}
-
-	// Perform the edit operation.
-	w.edit(tmpfile.Name())
-
-	// Read back the content of the file.
-	newContent, err := os.ReadFile(tmpfile.Name())
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	expected := []byte("bar qux bar qux")
-	if !bytes.Equal(newContent, expected) {
-		t.Errorf("After edit, content = %q; want %q", newContent, expected)
-	}
-}
-
-// TestWalkerEditFilename tests the editFilename function of the walker struct.
-func TestWalkerEditFilename(t *testing.T) {
-	// Create a temporary directory.
-	tmpDir, err := os.MkdirTemp("", "testdir")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	// Create a file with a name that needs to be replaced.
-	oldPath := filepath.Join(tmpDir, "foo.txt")
-	newPath := filepath.Join(tmpDir, "bar.txt")
-	if _, err := os.Create(oldPath); err != nil {
-		t.Fatal(err)
-	}
-
-	// Initialize the walker.
-	w := &walker{
-		ToStdout:      false,
-		Glob:          "*",
-		IsVerbose:     false,
-		MaxDepth:      -1,
-		IncludeHidden: true,
-		ReplaceNames:  true,
-		NamesOnly:     true,
-		pairs: pairset{
-			{
-				pattern:     regexp.MustCompile("foo"),
-				replacement: []byte("bar"),
-			},
-		},
-	}
-
-	// Perform the editFilename operation.
-	resultPath := w.editFilename(oldPath)
-
-	// Check if the file has been renamed.
-	if resultPath != newPath {
-		t.Errorf("editFilename result = %q; want %q", resultPath, newPath)
-	}
-	if _, err := os.Stat(newPath); os.IsNotExist(err) {
-		t.Errorf("Renamed file %q does not exist", newPath)
-	}
-}
-
-func TestWalkerEditStdin(t *testing.T) {
-	// Prepare the walker with replacement pairs
-	w := &walker{
-		ToStdout:      true,
-		Glob:          "*",
-		IsVerbose:     false,
-		MaxDepth:      -1,
-		IncludeHidden: true,
-		ReplaceNames:  false,
-		NamesOnly:     false,
-		pairs: pairset{
-			{
-				pattern:     regexp.MustCompile("foo"),
-				replacement: []byte("bar"),
-			},
-			{
-				pattern:     regexp.MustCompile("baz"),
-				replacement: []byte("qux"),
-			},
-		},
-		WaitGroup: new(sync.WaitGroup),
-	}
-
-	// Save original stdin and stdout
-	origStdin := os.Stdin
-	origStdout := os.Stdout
-	defer func() {
-		os.Stdin = origStdin
-		os.Stdout = origStdout
-	}()
-
-	// Create a pipe to simulate stdin
-	rStdin, wStdin, err := os.Pipe()
-	if err != nil {
-		t.Fatalf("failed to create pipe for stdin: %v", err)
-	}
-	os.Stdin = rStdin
-
-	// Create a pipe to capture stdout
-	rStdout, wStdout, err := os.Pipe()
-	if err != nil {
-		t.Fatalf("failed to create pipe for stdout: %v", err)
-	}
-	os.Stdout = wStdout
-
-	// Write input to stdin (include a null byte to mark the end)
-	input := []byte("foo baz foo\x00")
-	if _, err := wStdin.Write(input); err != nil {
-		t.Fatalf("failed to write to stdin: %v", err)
-	}
-	wStdin.Close() // End of input
-
-	// Run editStdin which reads from os.Stdin and writes to os.Stdout
-	w.editStdin()
-
-	// Close stdout writer to allow reading
-	wStdout.Close()
-
-	// Read output
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, rStdout); err != nil {
-		t.Fatalf("failed to read stdout: %v", err)
-	}
-
-	output := buf.String()
-	expected := "bar qux bar\x00"
-
-	if output != expected {
-		t.Errorf("expected %q, got %q", expected, output)
-	}
-}
-
-// TestWalkerEditInvalidPath tests the edit function of the walker struct with a non-existent file.
-func TestWalkerEditInvalidPath(t *testing.T) {
-	// Capture stdout to check error message.
-	origStdout := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	wk := &walker{
-		ToStdout:      false,
-		Glob:          "*",
-		IsVerbose:     false,
-		MaxDepth:      -1,
-		IncludeHidden: true,
-		ReplaceNames:  false,
-		NamesOnly:     false,
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-		WaitGroup: new(sync.WaitGroup),
-	}
-
-	wk.edit("non_existent_file.txt")
-
-	w.Close()
-	os.Stdout = origStdout
-
-	var buf bytes.Buffer
-	io.Copy(&buf, r)
-	r.Close()
-
-	output := buf.String()
-	if !strings.Contains(output, "no such file or directory") {
-		t.Errorf("expected error about missing file, got: %s", output)
-	}
-}
-
-// TestDepth tests the depth function.
-func TestDepth(t *testing.T) {
-	tests := []struct {
-		path     string
-		expected int
-	}{
-		{"", 1},
-		{"a/b/c", 3},
-		{"/a/b/c", 4},
-	}
-
-	for _, test := range tests {
-		result := depth(test.path)
-		if result != test.expected {
-			t.Errorf("depth(%q) = %d; want %d", test.path, result, test.expected)
-		}
-	}
-}
-
-// TestIsHidden tests the isHidden function.
-func TestIsHidden(t *testing.T) {
-	tests := []struct {
-		name     string
-		expected bool
-	}{
-		{".hidden", true},
-		{"..", false},
-		{".", false},
-		{"visible", false},
-	}
-
-	for _, test := range tests {
-		result := isHidden(test.name)
-		if result != test.expected {
-			t.Errorf("isHidden(%q) = %v; want %v", test.name, result, test.expected)
-		}
-	}
-}
-
-// TestProcessFile tests the processFile function of the walker struct.
-func TestProcessFile(t *testing.T) {
-	// Create a temporary directory with files.
-	tmpDir, err := os.MkdirTemp("", "testdir")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	// Create files and directories.
-	files := []string{
-		"foo.txt",
-		".hidden.txt",
-		"subdir/bar.txt",
-		"subdir/.hidden_sub.txt",
-	}
-	for _, file := range files {
-		fullPath := filepath.Join(tmpDir, file)
-		if strings.Contains(file, "/") {
-			os.MkdirAll(filepath.Dir(fullPath), 0755)
-		}
-		if _, err := os.Create(fullPath); err != nil {
-			t.Fatal(err)
-		}
-	}
-
-	// Initialize the walker.
-	w := &walker{
-		ToStdout:      false,
-		Glob:          "*.txt",
-		IsVerbose:     false,
-		MaxDepth:      -1,
-		IncludeHidden: false,
-		ReplaceNames:  false,
-		NamesOnly:     false,
-		pairs: pairset{
-			{
-				pattern:     regexp.MustCompile("foo"),
-				replacement: []byte("bar"),
-			},
-		},
-		WaitGroup: new(sync.WaitGroup),
-	}
-
-	// Walk the directory.
-	w.Walk(tmpDir)
-
-	// Wait for all goroutines to finish.
-	w.Wait()
-
-	// Check that the non-hidden file has been edited.
-	content, err := os.ReadFile(filepath.Join(tmpDir, "foo.txt"))
-	if err != nil {
-		t.Fatal(err)
-	}
-	expected := []byte{}
-	if !bytes.Equal(content, expected) {
-		t.Errorf("Expected content of 'foo.txt' to be empty; got %q", content)
-	}
-
-	// Check that the hidden file has not been edited.
-	content, err = os.ReadFile(filepath.Join(tmpDir, ".hidden.txt"))
-	if err != nil {
-		t.Fatal(err)
-	}
-	if !bytes.Equal(content, expected) {
-		t.Errorf("Expected content of '.hidden.txt' to be empty; got %q", content)
-	}
-}
-
-// TestPairsetString tests the String method of the pairset type.
-func TestPairsetString(t *testing.T) {
-	tests := []struct {
-		name     string
-		pairs    pairset
-		expected string
-	}{
-		{
-			name: "Single Pair",
-			pairs: pairset{
-				{
-					pattern:     regexp.MustCompile("foo"),
-					replacement: []byte("bar"),
-				},
-			},
-			expected: "['foo', 'bar']",
-		},
-		{
-			name: "Multiple Pairs",
-			pairs: pairset{
-				{
-					pattern:     regexp.MustCompile("foo"),
-					replacement: []byte("bar"),
-				},
-				{
-					pattern:     regexp.MustCompile("baz"),
-					replacement: []byte("qux"),
-				},
-			},
-			expected: "['foo', 'bar'] ['baz', 'qux']",
-		},
-		{
-			name:     "Empty Pairset",
-			pairs:    pairset{},
-			expected: "",
-		},
-		{
-			name: "Special Characters",
-			pairs: pairset{
-				{
-					pattern:     regexp.MustCompile(`a\w+b`),
-					replacement: []byte("replacement"),
-				},
-			},
-			expected: "['a\\w+b', 'replacement']",
-		},
-	}
-
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			result := test.pairs.String()
-			if result != test.expected {
-				t.Errorf("pairset.String() = %q; want %q", result, test.expected)
-			}
-		})
-	}
-}
-
 func TestParseFlags(t *testing.T) {
 	// Save the original command-line arguments and restore after the test.
 	origArgs := os.Args
@@ -588,21 +106,18 @@ func TestParseFlags(t *testing.T) {
 	if !w.NamesOnly {
 		t.Errorf("expected NamesOnly to be true, got false")
 	}
-	if w.WaitGroup == nil {
-		t.Errorf("expected WaitGroup to be initialized, got nil")
-	}
 
-	// Check that w.pairs is initialized correctly.
-	if len(w.pairs) != 1 {
-		t.Fatalf("expected 1 pair, got %d", len(w.pairs))
+	// Check that w.Rules is initialized correctly.
+	if len(w.Rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(w.Rules))
 	}
 
-	p := w.pairs[0]
-	if p.pattern.String() != "foo" {
-		t.Errorf("expected pattern 'foo', got %s", p.pattern.String())
+	r := w.Rules[0]
+	if r.Pattern.String() != "foo" {
+		t.Errorf("expected pattern 'foo', got %s", r.Pattern.String())
 	}
-	if string(p.replacement) != "bar" {
-		t.Errorf("expected replacement 'bar', got %s", p.replacement)
+	if r.Replacement != "bar" {
+		t.Errorf("expected replacement 'bar', got %s", r.Replacement)
 	}
 
 	// Check the files slice
@@ -667,6 +182,87 @@ Options:
   -l int                   Maximum depth for directory traversal.
   -r, --replace-names      Replace matches in file and directory names.
   -n, --names-only         Only replace matching names, ignoring file contents.
+  --replace-paths          Replace matches in the paths of the files relative
+                           to the given directories and move the files to the
+                           resulting paths, creating the missing directories.
+  --update-refs            Update the references to the renamed files in the
+                           processed files: Go import paths, C includes, JS
+                           and TS relative imports, and the relative paths and
+                           file names in any other text file.
+  -L, --line-mode          Apply the patterns to each line separately.
+  --stream-threshold int   Stream files larger than the given size in bytes
+                           instead of loading them in memory (default 64MiB).
+  --max-match int          Maximum length in bytes of a match when streaming
+                           (default 4096).
+  --files-from FILE        Read the paths of the files to edit from FILE, one
+                           per line, or from stdin if FILE is "-".
+                           The listed directories are not descended into.
+  -0                       The paths read with --files-from are separated by
+                           NUL characters instead of newlines.
+  --git                    Only process the files tracked by git and rename
+                           them with "git mv".
+  --git-changed            Like --git but only process the files with changes
+                           in the working tree or in the index. Implies
+                           --allow-dirty.
+  --allow-dirty            Edit files even if they have uncommitted changes in
+                           their git repository.
+  --keep-mtime             Preserve the modification time of the edited files.
+  --follow-symlinks        Descend into symbolic links to directories.
+  --no-follow              Never edit files through symbolic links, only
+                           rename the links themselves.
+  --within-roots           Never edit files that, once all the symbolic links
+                           are resolved, are outside of the given paths.
+  --only-comments          Only replace matches in comments.
+  --only-strings           Only replace matches in string literals.
+  --only-code              Only replace matches outside of comments and string
+                           literals.
+  --key path               Only replace matches in the values at the given key
+                           path of JSON, YAML and TOML files, leaving the rest
+                           of their formatting as is, e.g. spec.containers[0]
+                           or spec.containers.*.image; the other files are
+                           not edited.
+  --csv-column column      Only replace matches in the cells of the column with
+                           the given name or index, starting from 1, of CSV
+                           and TSV files, preserving the rest of the file; the
+                           other files are not edited. Can be used multiple
+                           times.
+  --csv-delimiter char     The delimiter of the fields (default "," or a tab
+                           for the TSV files).
+  --csv-quote char         The quote of the fields, empty to disable quoting
+                           (default '"').
+  --encoding name          Read and write the files in the given encoding:
+                           utf-8, utf-16le, utf-16be, utf-32le, utf-32be,
+                           latin1 or windows-1252 (default detected from the
+                           BOM or the content).
+  --eol mode               Convert the line endings of the edited files to lf
+                           or crlf, or preserve them (default). The CRLF line
+                           endings are matched as LF, so that $ and . behave
+                           the same. No pattern is required to convert them.
+  --normalize form         Match the patterns and the files in the Unicode
+                           normalization form nfc, nfd, nfkc or nfkd, so that
+                           precomposed and decomposed characters match alike.
+                           The text not replaced keeps its original form.
+  --normalize-output       Write the whole edited files in the form set with
+                           --normalize. No pattern is required to only
+                           normalize them.
+  --header format          Print the header of each file with -p, even a single
+                           one, in the given format where %s is replaced by
+                           the path (default "==> %s <==" when printing many
+                           files).
+  --no-header              Never print the headers of the files with -p.
+  --changed-only           Only print or write the files whose content
+                           changes.
+  --out-dir DIR            Write the edited files to DIR, at their path
+                           relative to the given directories, instead of in
+                           place. The renamed files are written to their new
+                           paths and the original files are left untouched.
+  --copy-unchanged         Copy to the --out-dir directory the files that are
+                           not edited, to get a full copy of the tree.
+  --go-ident old new       Rename the Go identifier old, a package level name
+                           or a method or field in the form Type.Member, to
+                           new in its declaration and uses only. Prefix old
+                           with an import path, e.g. example.com/m/pkg.Name or
+                           "pkg".Name, to only rename it in that package.
   -e pattern replacement   Specify a regular expression pattern and replacement.
                            Can be used multiple times for multiple replacements.
   -h, --help               Prints this help message and exits.
@@ -674,6 +270,26 @@ Options:
 Notice:
   When using the -e flag multiple times, the pattern-replacement pairs are
   executed in the same order they are specified, one by one.
+  Files are streamed in chunks, so in that case matches longer than the value
+  of --max-match might not be replaced.
+  When reading from stdin the output is written as soon as it is processed,
+  use -L to have it written line by line, e.g. in "tail -f log | jet -L ...".
+  Jet refuses to run if any of the files it would modify has uncommitted
+  changes in its git repository, so that every edit can be reverted with git.
+  By default the files pointed by symbolic links are edited, while symbolic
+  links to directories are not descended into. Renaming a symbolic link never
+  renames its target.
+  Each file is edited only once, even if it is reachable through overlapping
+  paths, hard links or symbolic links.
+  All the renames are checked before performing them and if two files would
+  be renamed to the same path, or to the path of an existing file, no file is
+  renamed.
+  The comments and the string literals are found by the file extension for
+  the most common languages, the files of other languages are made only of
+  code. The --only-* options can be combined.
+  With --go-ident no pattern and replacement are expected; the packages of
+  the walked Go files are type checked, so comments, strings and unrelated
+  identifiers with the same name are left alone.
 
 Examples:
   jet "foo" "bar" my/path1 my/path2
@@ -692,6 +308,9 @@ Examples:
     Replace "foo" with "bar" and "baz" with "qux" in all text files,
     including hidden files, under my/path1.
 
+  git grep -lz "foo" | jet -0 --files-from - "foo" "bar"
+    Replace "foo" with "bar" in the files tracked by git containing "foo".
+
 Jet Copyright (C) 2023  Nicolò Santamaria
 This program comes with ABSOLUTELY NO WARRANTY; for details refer to
 https://www.gnu.org/licenses/gpl-3.0.html.
@@ -704,384 +323,71 @@ under the conditions defined by the license.
 	}
 }
 
-// Utility to capture stdout.
-func captureStdout(f func()) string {
-	origStdout := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-	f()
-	w.Close()
-	os.Stdout = origStdout
-	var buf bytes.Buffer
-	io.Copy(&buf, r)
-	r.Close()
-	return buf.String()
-}
-
-// MockDirEntry for testing processFile.
-type MockDirEntry struct {
-	name  string
-	isDir bool
-}
-
-func (m MockDirEntry) Name() string               { return m.name }
-func (m MockDirEntry) IsDir() bool                { return m.isDir }
-func (m MockDirEntry) Type() fs.FileMode          { return 0 }
-func (m MockDirEntry) Info() (fs.FileInfo, error) { return nil, nil }
-
-func TestWalkerEdit_ErrorReadingFile(t *testing.T) {
-	w := &walker{
-		pairs: pairset{{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")}},
-	}
-
-	// Try editing a non-existent file.
-	output := captureStdout(func() {
-		w.edit("non_existent_file.txt")
-	})
-	if !strings.Contains(output, "no such file or directory") {
-		t.Errorf("expected error message about missing file, got: %s", output)
-	}
-}
-
-func TestWalkerEdit_ToStdout(t *testing.T) {
-	// Create a temp file
-	tmpfile, err := os.CreateTemp("", "testfile")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.Remove(tmpfile.Name())
-
-	content := []byte("foo baz")
-	tmpfile.Write(content)
-	tmpfile.Close()
-
-	w := &walker{
-		ToStdout: true,
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-	}
-
-	output := captureStdout(func() {
-		w.edit(tmpfile.Name())
-	})
-
-	if output != "bar baz" {
-		t.Errorf("expected replaced output 'bar baz', got %q", output)
-	}
-}
-
-func TestWalkerEdit_Verbose(t *testing.T) {
-	// Create a temp file
-	tmpfile, err := os.CreateTemp("", "testfile")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.Remove(tmpfile.Name())
-
-	tmpfile.Write([]byte("foo"))
-	tmpfile.Close()
-
-	w := &walker{
-		IsVerbose: true,
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-	}
-
-	output := captureStdout(func() {
-		w.edit(tmpfile.Name())
-	})
-	if !strings.Contains(output, "writing") {
-		t.Errorf("expected 'writing' in verbose output, got %q", output)
-	}
-}
-
-func TestWalkerEdit_StatError(t *testing.T) {
-	// Stat error can be simulated by removing file before stat.
-	tmpfile, err := os.CreateTemp("", "testfile")
-	if err != nil {
-		t.Fatal(err)
-	}
-	name := tmpfile.Name()
-	tmpfile.Write([]byte("foo"))
-	tmpfile.Close()
-	os.Remove(name) // remove the file to cause a stat error later
-
-	w := &walker{
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-	}
-
-	output := captureStdout(func() {
-		w.edit(name)
-	})
-	if !strings.Contains(output, "no such file or directory") {
-		t.Errorf("expected stat error, got %q", output)
-	}
-}
-
-func TestWalkerEdit_WriteError(t *testing.T) {
-	// Cause write error by using a directory instead of a file
-	tmpdir, err := os.MkdirTemp("", "testdir")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tmpdir)
-
-	w := &walker{
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-	}
-
-	output := captureStdout(func() {
-		// Attempting to write to a directory path should fail
-		w.edit(tmpdir)
-	})
-	if !strings.Contains(output, "is a directory") {
-		t.Errorf("expected write error for directory, got %q", output)
-	}
-}
-
-// Tests for editFilename
-func TestWalkerEditFilename_NoChange(t *testing.T) {
-	w := &walker{
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-	}
-
-	// If filename does not contain "foo" no change is made.
-	oldPath := "somefile.txt"
-	newPath := w.editFilename(oldPath)
-	if newPath != oldPath {
-		t.Errorf("expected no change, got %q", newPath)
-	}
-}
-
-func TestWalkerEditFilename_ChangeAndRenameSuccess(t *testing.T) {
-	tmpdir, err := os.MkdirTemp("", "testdir")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tmpdir)
-
-	oldPath := filepath.Join(tmpdir, "foo.txt")
-	if _, err := os.Create(oldPath); err != nil {
-		t.Fatal(err)
-	}
-
-	w := &walker{
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-		IsVerbose: true,
-	}
-
-	output := captureStdout(func() {
-		newPath := w.editFilename(oldPath)
-		expected := filepath.Join(tmpdir, "bar.txt")
-		if newPath != expected {
-			t.Errorf("expected newPath %q, got %q", expected, newPath)
-		}
-	})
-
-	if !strings.Contains(output, "renaming") {
-		t.Errorf("expected 'renaming' in verbose output")
-	}
-}
-
-func TestWalkerEditFilename_RenameError(t *testing.T) {
-	// Attempt rename to a non-writable directory to force error
-	tmpdir, err := os.MkdirTemp("", "testdir")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(tmpdir)
-
-	oldPath := filepath.Join(tmpdir, "foo.txt")
-	if _, err := os.Create(oldPath); err != nil {
-		t.Fatal(err)
-	}
-
-	// Make the directory read-only
-	os.Chmod(tmpdir, 0500)
-	defer os.Chmod(tmpdir, 0700) // restore permissions
-
-	w := &walker{
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-	}
-
-	output := captureStdout(func() {
-		newPath := w.editFilename(oldPath)
-		if newPath != oldPath {
-			t.Errorf("expected to return oldPath on error, got %q", newPath)
-		}
-	})
+// TestParseFlagsFilesFrom tests that the paths can be omitted when reading
+// them with --files-from.
+func TestParseFlagsFilesFrom(t *testing.T) {
+	origArgs := os.Args
+	defer func() { os.Args = origArgs }()
 
-	if !strings.Contains(output, "permission denied") {
-		t.Errorf("expected rename error message, got %q", output)
-	}
-}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"cmd", "--files-from", "-", "-0", "foo", "bar"}
 
-// Tests for processFile
-func TestWalkerProcessFile_WithErrorParameter(t *testing.T) {
-	w := &walker{
-		Glob:          "*",
-		MaxDepth:      -1,
-		IncludeHidden: true,
-		WaitGroup:     new(sync.WaitGroup),
+	w, files := parseFlags()
+	if w.FilesFrom != "-" {
+		t.Errorf("expected FilesFrom to be '-', got %q", w.FilesFrom)
 	}
-
-	output := captureStdout(func() {
-		w.processFile("anyfile.txt", MockDirEntry{name: "anyfile.txt", isDir: false},
-			fs.ErrNotExist) // Simulated error
-	})
-	if !strings.Contains(output, "file does not exist") && !strings.Contains(output, "no such file") {
-		t.Errorf("expected error message, got %q", output)
+	if !w.NulSeparated {
+		t.Errorf("expected NulSeparated to be true, got false")
 	}
-}
-
-func TestWalkerProcessFile_ExceedMaxDepth(t *testing.T) {
-	w := &walker{
-		MaxDepth:  1,
-		WaitGroup: new(sync.WaitGroup),
+	if len(files) != 0 {
+		t.Errorf("expected no files, got %v", files)
 	}
-
-	// depth("a/b/c") = 3, which is greater than MaxDepth=1
-	err := w.processFile("a/b/c", MockDirEntry{name: "c", isDir: true}, nil)
-	if err != fs.SkipDir {
-		t.Errorf("expected fs.SkipDir for exceeding max depth, got %v", err)
+	if len(w.Rules) != 1 || w.Rules[0].Pattern.String() != "foo" || w.Rules[0].Replacement != "bar" {
+		t.Errorf("unexpected rules %v", w.Rules)
 	}
 }
 
-func TestWalkerProcessFile_HiddenFilesNotIncluded(t *testing.T) {
-	w := &walker{
-		IncludeHidden: false,
-		MaxDepth:      -1,
-		WaitGroup:     new(sync.WaitGroup),
-	}
-
-	// Hidden file
-	err := w.processFile(".hiddenfile", MockDirEntry{name: ".hiddenfile", isDir: false}, nil)
-	if err != nil {
-		t.Errorf("expected nil, got %v", err)
-	}
-	// Since it's hidden and not included, no action is taken.
-	// To confirm no goroutine started, we can rely on coverage or manual checks.
-}
-
-func TestWalkerProcessFile_HiddenDirsNotIncluded(t *testing.T) {
-	w := &walker{
-		IncludeHidden: false,
-		WaitGroup:     new(sync.WaitGroup),
-	}
+// TestParseFlagsGoIdent tests that --go-ident takes the old and the new name
+// and that no pattern is expected then.
+func TestParseFlagsGoIdent(t *testing.T) {
+	origArgs := os.Args
+	defer func() { os.Args = origArgs }()
 
-	err := w.processFile(".hiddendir", MockDirEntry{name: ".hiddendir", isDir: true}, nil)
-	if err != fs.SkipDir {
-		t.Errorf("expected fs.SkipDir for hidden directory, got %v", err)
-	}
-}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"cmd", "--go-ident", "T.Old", "New", "-v", "dir"}
 
-func TestWalkerProcessFile_MatchesGlobAndNamesOnly(t *testing.T) {
-	w := &walker{
-		Glob:      "*.txt",
-		NamesOnly: true,
-		WaitGroup: new(sync.WaitGroup),
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
+	w, files := parseFlags()
+	if w.GoIdent != "T.Old" || w.GoIdentNew != "New" {
+		t.Errorf("unexpected identifiers %q and %q", w.GoIdent, w.GoIdentNew)
 	}
-
-	tmpdir, err := os.MkdirTemp("", "testdir")
-	if err != nil {
-		t.Fatal(err)
+	if !w.IsVerbose {
+		t.Errorf("expected IsVerbose to be true, got false")
 	}
-	defer os.RemoveAll(tmpdir)
-
-	oldFile := filepath.Join(tmpdir, "foo.txt")
-	os.Create(oldFile)
-
-	// processFile should trigger a rename in a goroutine
-	output := captureStdout(func() {
-		err := w.processFile(oldFile, MockDirEntry{name: "foo.txt", isDir: false}, nil)
-		if err != nil {
-			t.Fatal(err)
-		}
-		// Wait for goroutine
-		w.Wait()
-	})
-
-	newFile := filepath.Join(tmpdir, "bar.txt")
-	if _, err := os.Stat(newFile); os.IsNotExist(err) {
-		t.Errorf("expected renamed file 'bar.txt' to exist")
+	if len(files) != 1 || files[0] != "dir" {
+		t.Errorf("expected files [dir], got %v", files)
 	}
-
-	// NamesOnly means no content edit, just rename. Check output if verbose not set - no direct output expected.
-	if output != "" {
-		// Might print errors if any. It's okay if it's empty.
+	if w.Rules != nil {
+		t.Errorf("expected no rules, got %v", w.Rules)
 	}
 }
 
-func TestWalkerProcessFile_MatchesGlobAndEditFile(t *testing.T) {
-	w := &walker{
-		Glob:      "*.txt",
-		NamesOnly: false,
-		WaitGroup: new(sync.WaitGroup),
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-	}
-
-	// Create a temp file that matches glob and contains "foo".
-	tmpdir, err := os.MkdirTemp("", "testdir")
-	if err != nil {
-		t.Fatal(err)
+// TestFlagError tests that the errors name the flags instead of the fields of
+// the options, leaving the paths of the files as they are.
+func TestFlagError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&jet.OptionError{Msg: "NormalizeOutput requires Normalize and cannot be used with LineMode"}, "--normalize-output requires --normalize and cannot be used with -L"},
+		{&jet.OptionError{Msg: "Header and NoHeader are mutually exclusive"}, "--header and --no-header are mutually exclusive"},
+		{&jet.OptionError{Msg: "KeyPath cannot be used with LineMode or the Only* options"}, "--key cannot be used with -L or the --only-* options"},
+		{&jet.DirtyError{Paths: []string{"Header.h", "OutDir/a.txt"}}, "refusing to edit files with uncommitted changes, commit or stash them or use --allow-dirty:\n  Header.h\n  OutDir/a.txt"},
+		{fmt.Errorf("open Header.h: %w", errors.New("no such file")), "open Header.h: no such file"},
 	}
-	defer os.RemoveAll(tmpdir)
 
-	filePath := filepath.Join(tmpdir, "test.txt")
-	os.WriteFile(filePath, []byte("foo"), 0644)
-
-	output := captureStdout(func() {
-		err := w.processFile(filePath, MockDirEntry{name: "test.txt", isDir: false}, nil)
-		if err != nil {
-			t.Fatal(err)
+	for _, test := range tests {
+		if msg := flagError(test.err); msg != test.expected {
+			t.Errorf("flagError(%q) = %q; want %q", test.err, msg, test.expected)
 		}
-		w.Wait()
-	})
-
-	// Check file content edited
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if string(content) != "bar" {
-		t.Errorf("expected content 'bar', got %q", content)
-	}
-
-	// output might be empty or contain error messages if something went wrong
-	if strings.Contains(output, "error") {
-		t.Errorf("unexpected error output: %s", output)
-	}
-}
-
-func TestWalkerProcessFile_NoMatchGlob(t *testing.T) {
-	w := &walker{
-		Glob:      "*.md",
-		WaitGroup: new(sync.WaitGroup),
-		pairs: pairset{
-			{pattern: regexp.MustCompile("foo"), replacement: []byte("bar")},
-		},
-	}
-
-	err := w.processFile("test.txt", MockDirEntry{name: "test.txt"}, nil)
-	if err != nil {
-		t.Errorf("expected no error if glob does not match, got %v", err)
 	}
-	// No edits, no renames, no actions taken.
 }
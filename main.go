@@ -19,272 +19,200 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
-	"sync"
-)
-
-type pair struct {
-	pattern     *regexp.Regexp
-	replacement []byte
-}
-
-func (p pair) match(src []byte) bool {
-	return p.pattern.Match(src)
-}
 
-func (p pair) replaceAll(src []byte) []byte {
-	return p.pattern.ReplaceAll(src, p.replacement)
-}
+	"github.com/NicoNex/jet/jet"
+)
 
-type pairset []pair
+// ruleList is the value of the -e flag, which takes the pattern and the
+// replacement of a rule.
+type ruleList jet.RuleSet
 
-func (p *pairset) Set(pattern string) error {
-	re, err := regexp.Compile(pattern)
+func (r *ruleList) Set(pattern string) error {
+	rule, err := jet.NewRule(pattern, flag.Arg(0))
 	if err != nil {
-		return fmt.Errorf("invalid pattern: %w", err)
+		return err
 	}
 
-	*p = append(*p, pair{pattern: re, replacement: []byte(flag.Arg(0))})
+	*r = append(*r, rule)
 	flag.CommandLine.Parse(flag.Args()[1:])
 	return nil
 }
 
-func (p pairset) String() string {
-	var buf strings.Builder
+func (r ruleList) String() string {
+	return jet.RuleSet(r).String()
+}
 
-	for i, pair := range p {
-		buf.WriteString(fmt.Sprintf(
-			"['%v', '%s']",
-			pair.pattern,
-			pair.replacement,
-		))
+// columnList is the list of the columns set with --csv-column.
+type columnList []string
 
-		if i < len(p)-1 {
-			buf.WriteByte(' ')
-		}
-	}
-	return buf.String()
+func (c *columnList) Set(column string) error {
+	*c = append(*c, column)
+	return nil
 }
 
-func (p pairset) match(src []byte) bool {
-	for _, pair := range p {
-		if pair.match(src) {
-			return true
-		}
-	}
-	return false
+func (c columnList) String() string {
+	return strings.Join(c, " ")
 }
 
-func (p pairset) replaceAll(src []byte) []byte {
-	for _, pair := range p {
-		src = pair.replaceAll(src)
-	}
-	return src
+// goIdentFlag is the value of the --go-ident flag, which takes the old and
+// the new name of the identifier.
+type goIdentFlag struct {
+	old *string
+	new *string
 }
 
-type walker struct {
-	ToStdout      bool
-	Glob          string
-	IsVerbose     bool
-	MaxDepth      int
-	IncludeHidden bool
-	ReplaceNames  bool
-	NamesOnly     bool
-	pairs         pairset
-	*sync.WaitGroup
+func (g goIdentFlag) Set(old string) error {
+	*g.old, *g.new = old, flag.Arg(0)
+	flag.CommandLine.Parse(flag.Args()[1:])
+	return nil
 }
 
-func (w *walker) matchGlob(path string) bool {
-	ok, err := filepath.Match(w.Glob, filepath.Base(path))
-	if err != nil {
-		fmt.Println(err)
+func (g goIdentFlag) String() string {
+	if g.old == nil || *g.old == "" {
+		return ""
 	}
-	return ok
+	return fmt.Sprintf("['%s', '%s']", *g.old, *g.new)
 }
 
-func (w *walker) edit(path string) {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	if w.ToStdout {
-		fmt.Print(string(w.pairs.replaceAll(b)))
-		return
-	}
-
-	if w.IsVerbose {
-		fmt.Printf("writing %s\n", path)
-	}
-
-	info, err := os.Stat(path)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+func main() {
+	opts, files := parseFlags()
 
-	err = os.WriteFile(
-		path,
-		w.pairs.replaceAll(b),
-		info.Mode().Perm(),
-	)
-	if err != nil {
-		fmt.Println(err)
+	if _, err := jet.Run(context.Background(), opts, files...); err != nil {
+		fmt.Println(flagError(err))
+		os.Exit(1)
 	}
 }
 
-func (w *walker) editStdin() {
-	b, err := bufio.NewReader(os.Stdin).ReadBytes(0)
-	if err != nil && err != io.EOF {
-		fmt.Println(err)
-		return
-	}
-	fmt.Print(string(w.pairs.replaceAll(b)))
-}
+// flagNames replaces the fields of jet.Options with the flags setting them.
+var flagNames = strings.NewReplacer(
+	"AllowDirty", "--allow-dirty",
+	"CopyUnchanged", "--copy-unchanged",
+	"CSVColumns", "--csv-column",
+	"FollowSymlinks", "--follow-symlinks",
+	"Header", "--header",
+	"KeyPath", "--key",
+	"LineMode", "-L",
+	"NoFollow", "--no-follow",
+	"NoHeader", "--no-header",
+	"NormalizeOutput", "--normalize-output",
+	"Normalize", "--normalize",
+	"OnlyCode", "--only-code",
+	"OnlyComments", "--only-comments",
+	"OnlyStrings", "--only-strings",
+	"Only*", "--only-*",
+	"OutDir", "--out-dir",
+	"ToStdout", "-p",
+)
 
-func (w *walker) editFilename(path string) string {
+// flagError returns the message of err naming the flags instead of the
+// fields of jet.Options.
+func flagError(err error) string {
 	var (
-		base    = filepath.Base(path)
-		newbase = string(w.pairs.replaceAll([]byte(base)))
-		newpath = filepath.Join(filepath.Dir(path), newbase)
+		optErr   *jet.OptionError
+		dirtyErr *jet.DirtyError
 	)
-
-	// Return early if there are no changes in the name.
-	if newbase == base {
-		return path
+	switch {
+	case errors.As(err, &optErr):
+		return flagNames.Replace(optErr.Msg)
+	case errors.As(err, &dirtyErr):
+		// Only the first line, the others are the paths of the files.
+		msg, paths, _ := strings.Cut(dirtyErr.Error(), "\n")
+		return flagNames.Replace(msg) + "\n" + paths
 	}
-
-	if w.IsVerbose {
-		fmt.Printf("renaming %s to %s\n", path, newpath)
-	}
-
-	if err := os.Rename(path, newpath); err != nil {
-		fmt.Println(err)
-		return path
-	}
-	return newpath
-}
-
-func isHidden(name string) bool {
-	return name != "." && name != ".." && strings.HasPrefix(name, ".")
-}
-
-func (w *walker) processFile(path string, d fs.DirEntry, err error) error {
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-
-	// If the depth exceeds skip the entire directory.
-	if d.IsDir() && w.MaxDepth >= 0 && depth(path) > w.MaxDepth {
-		return fs.SkipDir
-	}
-	// Skip hidden files if not specified otherwise.
-	if isHidden(d.Name()) && !w.IncludeHidden {
-		if d.IsDir() {
-			return fs.SkipDir
-		}
-		return nil
-	}
-
-	if w.matchGlob(path) {
-		w.Add(1)
-		go func() {
-			defer w.Done()
-
-			if w.NamesOnly || w.ReplaceNames {
-				path = w.editFilename(path)
-			}
-			if !d.IsDir() && !w.NamesOnly && w.matchGlob(path) {
-				w.edit(path)
-			}
-		}()
-	}
-
-	return nil
+	return err.Error()
 }
 
-func (w *walker) Walk(paths ...string) {
-	defer w.Wait()
-
-	for _, p := range paths {
-		if p == "-" {
-			w.editStdin()
-		} else {
-			if err := filepath.WalkDir(p, w.processFile); err != nil {
-				fmt.Println(err)
-			}
-		}
-	}
-}
-
-func depth(path string) int {
-	return strings.Count(path, string(os.PathSeparator)) + 1
+// patternless reports whether an operation that doesn't need a pattern and a
+// replacement is requested.
+func patternless(o jet.Options) bool {
+	return o.GoIdent != "" || o.EOL == "lf" || o.EOL == "crlf" || o.NormalizeOutput
 }
 
-func main() {
-	w, files := parseFlags()
-	w.Walk(files...)
-}
-
-func containsDash(files []string) bool {
-	for _, f := range files {
-		if f == "-" {
-			return true
-		}
-	}
-	return false
-}
-
-func parseFlags() (w walker, files []string) {
+func parseFlags() (o jet.Options, files []string) {
+	d := jet.DefaultOptions()
 	flag.Usage = usage
-	flag.BoolVar(&w.ToStdout, "p", false, "Print to stdout.")
-	flag.BoolVar(&w.IsVerbose, "v", false, "Verbose, explain what is being done.")
-	flag.StringVar(&w.Glob, "g", "*", "Add a pattern the file names must match to be edited.")
-	flag.BoolVar(&w.IncludeHidden, "a", false, "Includes hidden files (starting with a dot).")
-	flag.IntVar(&w.MaxDepth, "l", -1, "Max depth.")
-	flag.BoolVar(&w.ReplaceNames, "r", false, "Replace matches in file and directory names.")
-	flag.BoolVar(&w.ReplaceNames, "replace-names", false, "Replace matches in file and directory names.")
-	flag.BoolVar(&w.NamesOnly, "n", false, "Only replace matches in names, ignoring file contents.")
-	flag.BoolVar(&w.NamesOnly, "names-only", false, "Only replace matches in names, ignoring file contents.")
-	flag.Var(&w.pairs, "e", "Specify two arguments per flag usage for executing a replacement operation.")
+	flag.BoolVar(&o.ToStdout, "p", false, "Print to stdout.")
+	flag.BoolVar(&o.IsVerbose, "v", false, "Verbose, explain what is being done.")
+	flag.StringVar(&o.Glob, "g", d.Glob, "Add a pattern the file names must match to be edited.")
+	flag.BoolVar(&o.IncludeHidden, "a", false, "Includes hidden files (starting with a dot).")
+	flag.IntVar(&o.MaxDepth, "l", d.MaxDepth, "Max depth.")
+	flag.BoolVar(&o.ReplaceNames, "r", false, "Replace matches in file and directory names.")
+	flag.BoolVar(&o.ReplaceNames, "replace-names", false, "Replace matches in file and directory names.")
+	flag.BoolVar(&o.NamesOnly, "n", false, "Only replace matches in names, ignoring file contents.")
+	flag.BoolVar(&o.NamesOnly, "names-only", false, "Only replace matches in names, ignoring file contents.")
+	flag.BoolVar(&o.UpdateRefs, "update-refs", false, "Update the references to the renamed files.")
+	flag.BoolVar(&o.ReplacePaths, "replace-paths", false, "Replace matches in the relative paths of the files, moving them.")
+	flag.BoolVar(&o.LineMode, "L", false, "Apply the patterns to each line separately.")
+	flag.BoolVar(&o.LineMode, "line-mode", false, "Apply the patterns to each line separately.")
+	flag.Int64Var(&o.StreamThreshold, "stream-threshold", d.StreamThreshold, "Stream files larger than the given size in bytes.")
+	flag.IntVar(&o.MaxMatch, "max-match", d.MaxMatch, "Maximum length in bytes of a match when streaming.")
+	flag.StringVar(&o.FilesFrom, "files-from", "", "Read the paths of the files to edit from the given file.")
+	flag.BoolVar(&o.NulSeparated, "0", false, "The paths read with --files-from are separated by NUL.")
+	flag.BoolVar(&o.Git, "git", false, "Only process the files tracked by git.")
+	flag.BoolVar(&o.GitChanged, "git-changed", false, "Only process the files tracked by git with uncommitted changes.")
+	flag.BoolVar(&o.AllowDirty, "allow-dirty", false, "Edit files with uncommitted changes in git.")
+	flag.BoolVar(&o.KeepMtime, "keep-mtime", false, "Preserve the modification time of the edited files.")
+	flag.BoolVar(&o.FollowSymlinks, "follow-symlinks", false, "Descend into symbolic links to directories.")
+	flag.BoolVar(&o.NoFollow, "no-follow", false, "Never edit files through symbolic links.")
+	flag.BoolVar(&o.WithinRoots, "within-roots", false, "Never edit files outside of the given paths.")
+	flag.Var((*ruleList)(&o.Rules), "e", "Specify two arguments per flag usage for executing a replacement operation.")
+	flag.BoolVar(&o.OnlyComments, "only-comments", false, "Only replace matches in comments.")
+	flag.BoolVar(&o.OnlyStrings, "only-strings", false, "Only replace matches in string literals.")
+	flag.BoolVar(&o.OnlyCode, "only-code", false, "Only replace matches outside of comments and string literals.")
+	flag.StringVar(&o.KeyPath, "key", "", "Only replace matches in the values at the given key path of JSON, YAML and TOML files.")
+	flag.Var((*columnList)(&o.CSVColumns), "csv-column", "Only replace matches in the given column of CSV and TSV files.")
+	flag.StringVar(&o.CSVDelimiter, "csv-delimiter", "", "The delimiter of the CSV fields.")
+	flag.StringVar(&o.CSVQuote, "csv-quote", d.CSVQuote, "The quote of the CSV fields, empty to disable quoting.")
+	flag.StringVar(&o.Encoding, "encoding", "", "The encoding of the files, detected by default.")
+	flag.StringVar(&o.EOL, "eol", d.EOL, "Convert the line endings to lf or crlf, or preserve them.")
+	flag.StringVar(&o.Normalize, "normalize", "", "Match the patterns and the files in the given Unicode normalization form.")
+	flag.BoolVar(&o.NormalizeOutput, "normalize-output", false, "Write the files in the normalization form set with --normalize.")
+	flag.StringVar(&o.Header, "header", "", "Print the header of each file with -p in the given format.")
+	flag.BoolVar(&o.NoHeader, "no-header", false, "Never print the headers of the files with -p.")
+	flag.BoolVar(&o.ChangedOnly, "changed-only", false, "Only print or write the files whose content changes.")
+	flag.StringVar(&o.OutDir, "out-dir", "", "Write the edited files to the given directory instead of in place.")
+	flag.BoolVar(&o.CopyUnchanged, "copy-unchanged", false, "Copy the files that are not edited to the --out-dir directory.")
+	flag.Var(goIdentFlag{&o.GoIdent, &o.GoIdentNew}, "go-ident", "Rename a Go identifier, specify the old and the new name.")
 	flag.Parse()
 
-	w.WaitGroup = new(sync.WaitGroup)
+	// The paths can be omitted if they are read from a file.
+	minFiles := 1
+	if o.FilesFrom != "" {
+		minFiles = 0
+	}
 
-	// Exit early if the pairs are set in the flags but no path is provided.
-	if w.pairs != nil && flag.NArg() < 1 {
+	// Exit early if the rules are set in the flags but no path is provided.
+	if (o.Rules != nil || patternless(o)) && flag.NArg() < minFiles {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// If no pair is provided using the -e flags:
+	// If no rule is provided using the -e flags and no other operation is
+	// requested:
 	//   - Expect the pattern and replacement in the first two command-line arguments.
 	//   - Process file paths starting from index 2.
 	// Otherwise:
 	//   - Process file paths starting from index 0.
-	if w.pairs == nil {
-		if flag.NArg() < 3 {
+	if o.Rules == nil && !patternless(o) {
+		if flag.NArg() < 2+minFiles {
 			flag.Usage()
 			os.Exit(1)
 		}
 
-		re, err := regexp.Compile(flag.Arg(0))
+		rule, err := jet.NewRule(flag.Arg(0), flag.Arg(1))
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		w.pairs = []pair{{pattern: re, replacement: []byte(flag.Arg(1))}}
+		o.Rules = jet.RuleSet{rule}
 
 		// Clean the user-provided paths.
 		for _, f := range flag.Args()[2:] {
@@ -296,10 +224,6 @@ func parseFlags() (w walker, files []string) {
 		}
 	}
 
-	if len(files) > 1 && containsDash(files) {
-		fmt.Println("cannot edit multiple files and stdin at the same time")
-		os.Exit(1)
-	}
 	return
 }
 
@@ -323,6 +247,87 @@ Options:
   -l int                   Maximum depth for directory traversal.
   -r, --replace-names      Replace matches in file and directory names.
   -n, --names-only         Only replace matching names, ignoring file contents.
+  --replace-paths          Replace matches in the paths of the files relative
+                           to the given directories and move the files to the
+                           resulting paths, creating the missing directories.
+  --update-refs            Update the references to the renamed files in the
+                           processed files: Go import paths, C includes, JS
+                           and TS relative imports, and the relative paths and
+                           file names in any other text file.
+  -L, --line-mode          Apply the patterns to each line separately.
+  --stream-threshold int   Stream files larger than the given size in bytes
+                           instead of loading them in memory (default 64MiB).
+  --max-match int          Maximum length in bytes of a match when streaming
+                           (default 4096).
+  --files-from FILE        Read the paths of the files to edit from FILE, one
+                           per line, or from stdin if FILE is "-".
+                           The listed directories are not descended into.
+  -0                       The paths read with --files-from are separated by
+                           NUL characters instead of newlines.
+  --git                    Only process the files tracked by git and rename
+                           them with "git mv".
+  --git-changed            Like --git but only process the files with changes
+                           in the working tree or in the index. Implies
+                           --allow-dirty.
+  --allow-dirty            Edit files even if they have uncommitted changes in
+                           their git repository.
+  --keep-mtime             Preserve the modification time of the edited files.
+  --follow-symlinks        Descend into symbolic links to directories.
+  --no-follow              Never edit files through symbolic links, only
+                           rename the links themselves.
+  --within-roots           Never edit files that, once all the symbolic links
+                           are resolved, are outside of the given paths.
+  --only-comments          Only replace matches in comments.
+  --only-strings           Only replace matches in string literals.
+  --only-code              Only replace matches outside of comments and string
+                           literals.
+  --key path               Only replace matches in the values at the given key
+                           path of JSON, YAML and TOML files, leaving the rest
+                           of their formatting as is, e.g. spec.containers[0]
+                           or spec.containers.*.image; the other files are
+                           not edited.
+  --csv-column column      Only replace matches in the cells of the column with
+                           the given name or index, starting from 1, of CSV
+                           and TSV files, preserving the rest of the file; the
+                           other files are not edited. Can be used multiple
+                           times.
+  --csv-delimiter char     The delimiter of the fields (default "," or a tab
+                           for the TSV files).
+  --csv-quote char         The quote of the fields, empty to disable quoting
+                           (default '"').
+  --encoding name          Read and write the files in the given encoding:
+                           utf-8, utf-16le, utf-16be, utf-32le, utf-32be,
+                           latin1 or windows-1252 (default detected from the
+                           BOM or the content).
+  --eol mode               Convert the line endings of the edited files to lf
+                           or crlf, or preserve them (default). The CRLF line
+                           endings are matched as LF, so that $ and . behave
+                           the same. No pattern is required to convert them.
+  --normalize form         Match the patterns and the files in the Unicode
+                           normalization form nfc, nfd, nfkc or nfkd, so that
+                           precomposed and decomposed characters match alike.
+                           The text not replaced keeps its original form.
+  --normalize-output       Write the whole edited files in the form set with
+                           --normalize. No pattern is required to only
+                           normalize them.
+  --header format          Print the header of each file with -p, even a single
+                           one, in the given format where %%s is replaced by
+                           the path (default "==> %%s <==" when printing many
+                           files).
+  --no-header              Never print the headers of the files with -p.
+  --changed-only           Only print or write the files whose content
+                           changes.
+  --out-dir DIR            Write the edited files to DIR, at their path
+                           relative to the given directories, instead of in
+                           place. The renamed files are written to their new
+                           paths and the original files are left untouched.
+  --copy-unchanged         Copy to the --out-dir directory the files that are
+                           not edited, to get a full copy of the tree.
+  --go-ident old new       Rename the Go identifier old, a package level name
+                           or a method or field in the form Type.Member, to
+                           new in its declaration and uses only. Prefix old
+                           with an import path, e.g. example.com/m/pkg.Name or
+                           "pkg".Name, to only rename it in that package.
   -e pattern replacement   Specify a regular expression pattern and replacement.
                            Can be used multiple times for multiple replacements.
   -h, --help               Prints this help message and exits.
@@ -330,6 +335,26 @@ Options:
 Notice:
   When using the -e flag multiple times, the pattern-replacement pairs are
   executed in the same order they are specified, one by one.
+  Files are streamed in chunks, so in that case matches longer than the value
+  of --max-match might not be replaced.
+  When reading from stdin the output is written as soon as it is processed,
+  use -L to have it written line by line, e.g. in "tail -f log | jet -L ...".
+  Jet refuses to run if any of the files it would modify has uncommitted
+  changes in its git repository, so that every edit can be reverted with git.
+  By default the files pointed by symbolic links are edited, while symbolic
+  links to directories are not descended into. Renaming a symbolic link never
+  renames its target.
+  Each file is edited only once, even if it is reachable through overlapping
+  paths, hard links or symbolic links.
+  All the renames are checked before performing them and if two files would
+  be renamed to the same path, or to the path of an existing file, no file is
+  renamed.
+  The comments and the string literals are found by the file extension for
+  the most common languages, the files of other languages are made only of
+  code. The --only-* options can be combined.
+  With --go-ident no pattern and replacement are expected; the packages of
+  the walked Go files are type checked, so comments, strings and unrelated
+  identifiers with the same name are left alone.
 
 Examples:
   %s "foo" "bar" my/path1 my/path2
@@ -348,6 +373,9 @@ Examples:
     Replace "foo" with "bar" and "baz" with "qux" in all text files,
     including hidden files, under my/path1.
 
+  git grep -lz "foo" | %s -0 --files-from - "foo" "bar"
+    Replace "foo" with "bar" in the files tracked by git containing "foo".
+
 Jet Copyright (C) 2023  Nicolò Santamaria
 This program comes with ABSOLUTELY NO WARRANTY; for details refer to
 https://www.gnu.org/licenses/gpl-3.0.html.
@@ -360,5 +388,6 @@ under the conditions defined by the license.
 		os.Args[0],
 		os.Args[0],
 		os.Args[0],
+		os.Args[0],
 	)
 }